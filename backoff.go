@@ -0,0 +1,141 @@
+package futurama
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// BackoffPolicy gives the delay before retrying an event which has already been retried attempt times.
+// Policies are shared by events, Backoff may be called concurrently
+type BackoffPolicy interface {
+	Backoff(attempt int) time.Duration
+}
+
+// how ExponentialBackoff randomizes retry delays
+const (
+	RetryJitter_NONE  = "none"  // no randomization
+	RetryJitter_FULL  = "full"  // between 0 and the delay
+	RetryJitter_EQUAL = "equal" // between half the delay and the delay
+	RetryJitter_ADD   = "add"   // between the delay and twice the delay
+)
+
+// ExponentialBackoff delays the n-th retry by Interval * Multiplier^(n-1),
+// capped at MaxInterval (if > 0) and randomized by Jitter
+type ExponentialBackoff struct {
+	Interval    time.Duration
+	Multiplier  float64
+	MaxInterval time.Duration
+	Jitter      string
+}
+
+func (self *ExponentialBackoff) Backoff(attempt int) time.Duration {
+	du := capDuration(float64(self.Interval)*math.Pow(self.Multiplier, float64(attempt)), self.MaxInterval)
+	if du <= 0 {
+		return 0
+	}
+	switch self.Jitter {
+	case RetryJitter_FULL:
+		du = time.Duration(rand.Int63n(int64(du)))
+	case RetryJitter_EQUAL:
+		du = du/2 + time.Duration(rand.Int63n(int64(du-du/2)))
+	case RetryJitter_ADD:
+		du += time.Duration(rand.Int63n(int64(du)))
+	}
+	if self.MaxInterval > 0 && du > self.MaxInterval {
+		du = self.MaxInterval
+	}
+	return du
+}
+
+// FullJitterBackoff delays the n-th retry randomly between 0 and Interval * 2^(n-1), capped at MaxInterval (if > 0)
+type FullJitterBackoff struct {
+	Interval    time.Duration
+	MaxInterval time.Duration
+}
+
+func (self *FullJitterBackoff) Backoff(attempt int) time.Duration {
+	du := capDuration(float64(self.Interval)*math.Pow(2, float64(attempt)), self.MaxInterval)
+	if du <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(du)))
+}
+
+// DecorrelatedJitterBackoff delays each retry randomly between Interval and 3 times the previous delay,
+// capped at MaxInterval (if > 0). Previous delays are not kept, they are drawn again for each retry
+type DecorrelatedJitterBackoff struct {
+	Interval    time.Duration
+	MaxInterval time.Duration
+}
+
+func (self *DecorrelatedJitterBackoff) Backoff(attempt int) time.Duration {
+	du := self.Interval
+	if du <= 0 {
+		return 0
+	}
+	for i := 0; i < attempt; i++ {
+		upper := capDuration(float64(du)*3, self.MaxInterval)
+		if upper <= self.Interval {
+			return upper
+		}
+		du = self.Interval + time.Duration(rand.Int63n(int64(upper-self.Interval)))
+	}
+	return du
+}
+
+// LinearBackoff delays the n-th retry by Interval + (n-1) * Increment, capped at MaxInterval (if > 0)
+type LinearBackoff struct {
+	Interval    time.Duration
+	Increment   time.Duration
+	MaxInterval time.Duration
+}
+
+func (self *LinearBackoff) Backoff(attempt int) time.Duration {
+	return capDuration(float64(self.Interval)+float64(self.Increment)*float64(attempt), self.MaxInterval)
+}
+
+// FixedBackoff delays every retry by Interval
+type FixedBackoff struct {
+	Interval time.Duration
+}
+
+func (self *FixedBackoff) Backoff(attempt int) time.Duration {
+	return self.Interval
+}
+
+// FibonacciBackoff delays retries by Interval times 1, 1, 2, 3, 5, 8..., capped at MaxInterval (if > 0)
+type FibonacciBackoff struct {
+	Interval    time.Duration
+	MaxInterval time.Duration
+}
+
+func (self *FibonacciBackoff) Backoff(attempt int) time.Duration {
+	a, b := 1.0, 1.0
+	for i := 0; i < attempt && !math.IsInf(b, 0); i++ {
+		a, b = b, a+b
+	}
+	return capDuration(float64(self.Interval)*a, self.MaxInterval)
+}
+
+// capDuration converts du to a Duration no longer than max (if > 0),
+// uncapped delays are kept short enough to be jittered and added to the current time
+func capDuration(du float64, max time.Duration) time.Duration {
+	if max > 0 && du > float64(max) {
+		return max
+	}
+	if du >= math.MaxInt64/4 {
+		return math.MaxInt64 / 4
+	}
+	return time.Duration(du)
+}
+
+// backoffPolicy returns the policy given by the retry_* settings
+func (self *TriggerTypeConfig) backoffPolicy() BackoffPolicy {
+	return &ExponentialBackoff{
+		Interval:    time.Duration(self.RetryIntervalMSec) * time.Millisecond,
+		Multiplier:  self.RetryMultiplier,
+		MaxInterval: time.Duration(self.RetryMaxIntervalMSec) * time.Millisecond,
+		Jitter:      self.RetryJitter,
+	}
+}
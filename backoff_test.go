@@ -0,0 +1,95 @@
+package futurama
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestBackoff_Exponential(t *testing.T) {
+	assert := assert.New(t)
+	policy := &ExponentialBackoff{
+		Interval:    time.Second,
+		Multiplier:  3,
+		MaxInterval: 20 * time.Second,
+		Jitter:      RetryJitter_NONE,
+	}
+	assert.Equal(1*time.Second, policy.Backoff(0))
+	assert.Equal(3*time.Second, policy.Backoff(1))
+	assert.Equal(9*time.Second, policy.Backoff(2))
+	assert.Equal(20*time.Second, policy.Backoff(3))
+	assert.Equal(20*time.Second, policy.Backoff(1000))
+
+	for i := 0; i < 100; i++ {
+		policy.Jitter = RetryJitter_FULL
+		du := policy.Backoff(2)
+		assert.True(du >= 0 && du < 9*time.Second, du)
+
+		policy.Jitter = RetryJitter_EQUAL
+		du = policy.Backoff(2)
+		assert.True(du >= 4500*time.Millisecond && du < 9*time.Second, du)
+
+		policy.Jitter = RetryJitter_ADD
+		du = policy.Backoff(2)
+		assert.True(du >= 9*time.Second && du <= 18*time.Second, du)
+		assert.Equal(20*time.Second, policy.Backoff(3))
+	}
+
+	// not capped
+	policy.MaxInterval = 0
+	assert.True(policy.Backoff(1000) > 0)
+	assert.True(time.Now().Add(policy.Backoff(1000)).After(time.Now()))
+}
+
+func TestBackoff_FullJitter(t *testing.T) {
+	assert := assert.New(t)
+	policy := &FullJitterBackoff{Interval: time.Second, MaxInterval: 10 * time.Second}
+	for i := 0; i < 100; i++ {
+		du := policy.Backoff(0)
+		assert.True(du >= 0 && du < time.Second, du)
+		du = policy.Backoff(2)
+		assert.True(du >= 0 && du < 4*time.Second, du)
+		du = policy.Backoff(100)
+		assert.True(du >= 0 && du < 10*time.Second, du)
+	}
+}
+
+func TestBackoff_DecorrelatedJitter(t *testing.T) {
+	assert := assert.New(t)
+	policy := &DecorrelatedJitterBackoff{Interval: time.Second, MaxInterval: 10 * time.Second}
+	assert.Equal(time.Second, policy.Backoff(0))
+	for i := 0; i < 100; i++ {
+		du := policy.Backoff(1)
+		assert.True(du >= time.Second && du < 3*time.Second, du)
+		du = policy.Backoff(2)
+		assert.True(du >= time.Second && du < 9*time.Second, du)
+		du = policy.Backoff(100)
+		assert.True(du >= time.Second && du < 10*time.Second, du)
+	}
+}
+
+func TestBackoff_Linear(t *testing.T) {
+	assert := assert.New(t)
+	policy := &LinearBackoff{Interval: time.Second, Increment: 2 * time.Second, MaxInterval: 6 * time.Second}
+	assert.Equal(1*time.Second, policy.Backoff(0))
+	assert.Equal(3*time.Second, policy.Backoff(1))
+	assert.Equal(5*time.Second, policy.Backoff(2))
+	assert.Equal(6*time.Second, policy.Backoff(3))
+	assert.Equal(6*time.Second, policy.Backoff(100))
+}
+
+func TestBackoff_Fixed(t *testing.T) {
+	assert := assert.New(t)
+	policy := &FixedBackoff{Interval: 3 * time.Second}
+	assert.Equal(3*time.Second, policy.Backoff(0))
+	assert.Equal(3*time.Second, policy.Backoff(100))
+}
+
+func TestBackoff_Fibonacci(t *testing.T) {
+	assert := assert.New(t)
+	policy := &FibonacciBackoff{Interval: time.Second, MaxInterval: time.Minute}
+	for i, expected := range []int{1, 1, 2, 3, 5, 8, 13, 21, 34, 55, 60, 60} {
+		assert.Equal(time.Duration(expected)*time.Second, policy.Backoff(i))
+	}
+	assert.Equal(time.Minute, policy.Backoff(100000))
+}
@@ -8,7 +8,102 @@ type Config struct {
 
 type SchedulerConfig struct {
 	MaxScheduledEvents int `json:"max_scheduled_events"`
-	MaxRetry           int `json:"max_retry"`
+	// SchedulerType_HEAP or SchedulerType_WHEEL, the wheel fires events every WheelTickMSec
+	SchedulerType string `json:"scheduler_type"`
+	WheelTickMSec int    `json:"wheel_tick_msec"`
+	MaxRetry      int    `json:"max_retry"`
+	// triggers running at once, events due over the limit wait in trigger time order, no limit if 0
+	MaxConcurrency int `json:"max_concurrency"`
+	// Queue.Stop waits for running triggers up to ShutdownTimeoutMSec before interrupting them
+	ShutdownTimeoutMSec int `json:"shutdown_timeout_msec"`
+	// interrupted triggers are waited for up to ShutdownGraceMSec, events of those still running are not released
+	ShutdownGraceMSec int `json:"shutdown_grace_msec"`
+
+	CronMissedPolicy string `json:"cron_missed_policy"`
+
+	// settings of trigger types missing in TriggerTypes, and of zero valued fields in TriggerTypes
+	TriggerTypeDefault TriggerTypeConfig             `json:"trigger_type_default"`
+	TriggerTypes       map[string]*TriggerTypeConfig `json:"trigger_types"`
+}
+
+type TriggerTypeConfig struct {
+	// what to do with events triggered more than LateThresholdMSec after their trigger time
+	LatePolicy        string `json:"late_policy"`
+	LateThresholdMSec int    `json:"late_threshold_msec"`
+	// late events are rescheduled within LateSpreadMSec from now by LatePolicy_SPREAD
+	LateSpreadMSec int `json:"late_spread_msec"`
+
+	// retries before giving up, SchedulerConfig.MaxRetry if 0 in TriggerTypeDefault, no retry if < 0
+	MaxRetry int `json:"max_retry"`
+	// the n-th retry is delayed by RetryIntervalMSec * RetryMultiplier^(n-1),
+	// capped at RetryMaxIntervalMSec and randomized by RetryJitter
+	RetryIntervalMSec    int     `json:"retry_interval_msec"`
+	RetryMultiplier      float64 `json:"retry_multiplier"`
+	RetryMaxIntervalMSec int     `json:"retry_max_interval_msec"`
+	RetryJitter          string  `json:"retry_jitter"`
+	// give up when a retry would be later than RetryMaxElapsedMSec after the first attempt, no limit if 0
+	RetryMaxElapsedMSec int `json:"retry_max_elapsed_msec"`
+
+	// triggers taking a context (ContextTriggerInterface) running longer are interrupted and retried, no timeout if 0
+	TimeoutMSec int `json:"timeout_msec"`
+	// triggers of the type running at once, within SchedulerConfig.MaxConcurrency, no limit if 0
+	MaxConcurrency int `json:"max_concurrency"`
+	// triggers of the type per second on each instance, events over the rate are delayed, no limit if 0
+	RateLimit float64 `json:"rate_limit"`
+	// triggers allowed at once within RateLimit, 1 if 0
+	RateBurst int `json:"rate_burst"`
+
+	// events triggered with EventStatus_PENDING and no TriggerResult.TriggerTime are retried
+	// if not acknowledged within AckTimeoutMSec
+	AckTimeoutMSec int `json:"ack_timeout_msec"`
+}
+
+// withDefault returns a copy of self whose zero valued fields are taken from def
+func (self *TriggerTypeConfig) withDefault(def *TriggerTypeConfig) *TriggerTypeConfig {
+	cfg := *self
+	if cfg.LatePolicy == "" {
+		cfg.LatePolicy = def.LatePolicy
+	}
+	if cfg.LateThresholdMSec == 0 {
+		cfg.LateThresholdMSec = def.LateThresholdMSec
+	}
+	if cfg.LateSpreadMSec == 0 {
+		cfg.LateSpreadMSec = def.LateSpreadMSec
+	}
+	if cfg.MaxRetry == 0 {
+		cfg.MaxRetry = def.MaxRetry
+	}
+	if cfg.RetryIntervalMSec == 0 {
+		cfg.RetryIntervalMSec = def.RetryIntervalMSec
+	}
+	if cfg.RetryMultiplier == 0 {
+		cfg.RetryMultiplier = def.RetryMultiplier
+	}
+	if cfg.RetryMaxIntervalMSec == 0 {
+		cfg.RetryMaxIntervalMSec = def.RetryMaxIntervalMSec
+	}
+	if cfg.RetryJitter == "" {
+		cfg.RetryJitter = def.RetryJitter
+	}
+	if cfg.RetryMaxElapsedMSec == 0 {
+		cfg.RetryMaxElapsedMSec = def.RetryMaxElapsedMSec
+	}
+	if cfg.TimeoutMSec == 0 {
+		cfg.TimeoutMSec = def.TimeoutMSec
+	}
+	if cfg.MaxConcurrency == 0 {
+		cfg.MaxConcurrency = def.MaxConcurrency
+	}
+	if cfg.RateLimit == 0 {
+		cfg.RateLimit = def.RateLimit
+	}
+	if cfg.RateBurst == 0 {
+		cfg.RateBurst = def.RateBurst
+	}
+	if cfg.AckTimeoutMSec == 0 {
+		cfg.AckTimeoutMSec = def.AckTimeoutMSec
+	}
+	return &cfg
 }
 
 type MySQLConfig struct {
@@ -26,6 +121,20 @@ type MySQLConfig struct {
 	ConsumerTimeWindowSec  int    `json:"consumer_time_window_sec"`
 	ConsumerSelectLimit    int    `json:"consumer_select_limit"`
 	ConsumerSleepMSec      int    `json:"consumer_sleep_msec"`
+	// sleep between polls is doubled while no event is claimed, up to ConsumerMaxSleepMSec
+	ConsumerMaxSleepMSec int `json:"consumer_max_sleep_msec"`
+
+	// events are spread over Partitions by the hash of their id, live consumers divide partitions by consistent hashing
+	// and claim events of their own partitions only. All consumers claim any event if 0, all consumers must use the same Partitions
+	Partitions        int    `json:"partitions"`
+	ConsumerTableName string `json:"consumer_table_name"`
+	// consumers send a heartbeat every ConsumerHeartbeatSec, and lose their partitions after missing 3 of them
+	ConsumerHeartbeatSec int `json:"consumer_heartbeat_sec"`
+	// points of each consumer on the hash ring, more of them divide partitions more evenly
+	ConsumerVirtualNodes int `json:"consumer_virtual_nodes"`
+
+	BulkChunkSize       int    `json:"bulk_chunk_size"`
+	DeadLetterTableName string `json:"dead_letter_table_name"`
 }
 
 func DefaultConfig() *Config {
@@ -34,6 +143,27 @@ func DefaultConfig() *Config {
 		SchedulerConfig: SchedulerConfig{
 			MaxScheduledEvents: 10000,
 			MaxRetry:           18,
+
+			SchedulerType: SchedulerType_HEAP,
+			WheelTickMSec: 10,
+
+			ShutdownTimeoutMSec: 5000,
+			ShutdownGraceMSec:   1000,
+
+			CronMissedPolicy: MissedPolicy_ONCE,
+
+			TriggerTypeDefault: TriggerTypeConfig{
+				LatePolicy:        LatePolicy_TRIGGER,
+				LateThresholdMSec: 2000,
+				LateSpreadMSec:    60000,
+
+				RetryIntervalMSec:    250,
+				RetryMultiplier:      2,
+				RetryMaxIntervalMSec: 600000,
+				RetryJitter:          RetryJitter_ADD,
+
+				AckTimeoutMSec: 300000,
+			},
 		},
 		MySQLConfig: MySQLConfig{
 			MySQL6:            true,
@@ -50,6 +180,15 @@ func DefaultConfig() *Config {
 			ConsumerTimeWindowSec:  5,
 			ConsumerSelectLimit:    50,
 			ConsumerSleepMSec:      100,
+			ConsumerMaxSleepMSec:   1000,
+
+			Partitions:           0,
+			ConsumerTableName:    "consumers",
+			ConsumerHeartbeatSec: 2,
+			ConsumerVirtualNodes: 64,
+
+			BulkChunkSize:       1000,
+			DeadLetterTableName: "dead_letters",
 		},
 	}
 }
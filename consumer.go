@@ -4,6 +4,7 @@ import (
 	"github.com/golang/glog"
 	"github.com/satori/go.uuid"
 	"runtime/debug"
+	"strings"
 	"time"
 )
 
@@ -14,8 +15,25 @@ type MySQLConsumer struct {
 	quitChan  chan chan bool
 	eventChan chan []*Event
 	seq       Seq32
+	// events which can be taken by the scheduler, not limited if nil
+	capacity func() int
+
+	// sleep between polls, from minSleep up to maxSleep while nothing is claimed
+	minSleep  time.Duration
+	maxSleep  time.Duration
+	idleSleep time.Duration
+
+	// partitions claimed by this consumer, nil if events are not partitioned
+	partitions []int
+	// live consumers the partitions have been divided between
+	consumers string
 
 	nbRecovered Seq32
+	nbFull      Seq32
+	nbIdle      Seq32
+	nbBurst     Seq32
+	nbClaimed   Seq32
+	nbRebalance Seq32
 	lastSeq     int32
 }
 
@@ -27,12 +45,23 @@ func NewMySQLConsumer(cfg *Config, store *MySQLStore) *MySQLConsumer {
 		ownerId = cfg.ConsumerName
 	}
 
+	var partitions []int
+	if cfg.Partitions > 0 {
+		// nothing is claimed until the partitions are divided
+		partitions = []int{}
+	}
+
 	return &MySQLConsumer{
 		ownerId:   ownerId,
 		store:     store,
 		cfg:       &cfg.MySQLConfig,
 		quitChan:  make(chan chan bool, 1),
 		eventChan: make(chan []*Event),
+
+		minSleep: time.Duration(cfg.ConsumerSleepMSec) * time.Millisecond,
+		maxSleep: time.Duration(cfg.ConsumerMaxSleepMSec) * time.Millisecond,
+
+		partitions: partitions,
 	}
 }
 
@@ -41,40 +70,49 @@ func (self *MySQLConsumer) Start() {
 		defer glog.Infoln("Consumer stop", self.ownerId)
 
 		var (
-			shouldStop    = false
-			consumerSleep = time.Duration(self.cfg.ConsumerSleepMSec) * time.Millisecond
+			shouldStop = false
+			sleep      time.Duration
 		)
 
 		timeoutCheckerInterval := time.Duration(self.cfg.ConsumerLockTimeoutSec*1000/4+1) * time.Millisecond
 		glog.Infoln("Check timeout events every", timeoutCheckerInterval, self.ownerId)
 		timeoutChecker := time.NewTicker(timeoutCheckerInterval)
+		defer timeoutChecker.Stop()
+
+		var heartbeat <-chan time.Time
+		if self.partitions != nil {
+			self.rebalance()
+			heartbeatTicker := time.NewTicker(time.Duration(self.cfg.ConsumerHeartbeatSec) * time.Second)
+			defer heartbeatTicker.Stop()
+			heartbeat = heartbeatTicker.C
+		}
 		for {
 			func() {
 				defer func() {
 					if r := recover(); r != nil {
 						glog.Errorf("Recovered in Consumer(%s), msg: %s stack: %s", self.ownerId, r, debug.Stack())
 						self.nbRecovered.Next()
+						sleep = self.minSleep
 					}
 				}()
 
 				select {
 				case c := <-self.quitChan:
+					if self.partitions != nil {
+						self.store.leave(self.ownerId)
+					}
 					close(c)
 					shouldStop = true
 					return
 				case <-timeoutChecker.C:
 					self.store.resetDelayedEvents(self.ownerId)
-				default:
-					consumerSeq := self.seq.Next()
-					if err, events := self.store.getEvents(consumerSeq, self.ownerId); err != nil {
-						glog.Errorln("getEvents:", err, self.ownerId)
-					} else {
-						if len(events) > 0 {
-							glog.Infof("Dispatch events nbEv: %d seq: %d %s", len(events), consumerSeq, self.ownerId)
-							self.eventChan <- events
-						}
+				case <-heartbeat:
+					if self.rebalance() {
+						// claims from new partitions at once
+						sleep = 0
 					}
-					time.Sleep(consumerSleep)
+				case <-time.After(sleep):
+					sleep = self.poll()
 				}
 			}()
 
@@ -87,6 +125,83 @@ func (self *MySQLConsumer) Start() {
 	glog.Infoln("Consumer start", self.ownerId)
 }
 
+// poll claims events and returns how long to wait before polling again:
+// no wait after a full batch, ConsumerSleepMSec after a partial one, and doubled after every empty one
+// up to ConsumerMaxSleepMSec, unless the next unclaimed event gets into the time window earlier
+func (self *MySQLConsumer) poll() time.Duration {
+	limit := self.limit()
+	if limit <= 0 {
+		// claimed events would be evicted at once, the ones already claimed are left to be triggered
+		self.nbFull.Next()
+		return self.minSleep
+	}
+	if self.partitions != nil && len(self.partitions) == 0 {
+		// more consumers than partitions, polled again once partitions are divided
+		self.nbIdle.Next()
+		return self.maxSleep
+	}
+	consumerSeq := self.seq.Next()
+	err, events := self.store.getEvents(consumerSeq, self.ownerId, limit, self.partitions)
+	if err != nil {
+		glog.Errorln("getEvents:", err, self.ownerId)
+	} else if len(events) > 0 {
+		glog.Infof("Dispatch events nbEv: %d seq: %d %s", len(events), consumerSeq, self.ownerId)
+		self.eventChan <- events
+	}
+
+	switch {
+	case len(events) >= limit:
+		self.idleSleep = 0
+		self.nbBurst.Next()
+		return 0
+	case len(events) > 0 || self.maxSleep <= self.minSleep:
+		self.idleSleep = 0
+		return self.minSleep
+	}
+
+	self.nbIdle.Next()
+	if self.idleSleep < self.minSleep {
+		self.idleSleep = self.minSleep
+	} else if self.idleSleep *= 2; self.idleSleep > self.maxSleep {
+		self.idleSleep = self.maxSleep
+	}
+	sleep := self.idleSleep
+	if next, err := self.store.nextTriggerTime(self.partitions); err == nil && !next.IsZero() {
+		// claimed as soon as it is in the time window
+		if du := next.Add(-self.store.timeWindow).Sub(time.Now()); du < sleep {
+			sleep = du
+		}
+	}
+	if sleep < self.minSleep {
+		sleep = self.minSleep
+	}
+	return sleep
+}
+
+// rebalance sends a heartbeat and takes the partitions given by the hash ring of live consumers,
+// returns true if they have changed. Partitions are kept on failure, other consumers take them over
+// once this one is missing
+func (self *MySQLConsumer) rebalance() bool {
+	consumers, err := self.store.heartbeat(self.ownerId)
+	if err != nil {
+		return false
+	}
+	if members := strings.Join(consumers, ","); members == self.consumers {
+		return false
+	} else {
+		self.consumers = members
+	}
+
+	partitions := newHashRing(consumers, self.cfg.ConsumerVirtualNodes).partitions(self.ownerId, self.cfg.Partitions)
+	if partitions == nil {
+		partitions = []int{}
+	}
+	glog.Infof("Rebalance nbConsumers: %d partitions: %v %s", len(consumers), partitions, self.ownerId)
+	self.partitions = partitions
+	self.nbRebalance.Next()
+	return true
+}
+
 func (self *MySQLConsumer) Stop() {
 	glog.Infoln("Stop consumer", self.ownerId)
 	c := make(chan bool)
@@ -94,17 +209,59 @@ func (self *MySQLConsumer) Stop() {
 	<-c
 }
 
+// SetCapacity limits claimed events to what the scheduler can take, it must be called before Start
+func (self *MySQLConsumer) SetCapacity(capacity func() int) {
+	self.capacity = capacity
+}
+
+// limit returns the number of events to claim, ConsumerSelectLimit within the capacity of the scheduler
+func (self *MySQLConsumer) limit() int {
+	limit := self.cfg.ConsumerSelectLimit
+	if self.capacity != nil {
+		if capacity := self.capacity(); capacity < limit {
+			limit = capacity
+		}
+	}
+	return limit
+}
+
+// Claim saves ev owned by this consumer if it is due within the time window and the scheduler has room for it,
+// it is scheduled at once instead of waiting for the next poll. Partitions of other consumers are not contended
+// by a new event, it is claimed whatever its partition
+func (self *MySQLConsumer) Claim(ev *Event) (*Event, error) {
+	if !ev.TriggerTime.Before(time.Now().Add(self.store.timeWindow)) || self.limit() <= 0 {
+		return nil, nil
+	}
+	// a sequence of its own, claimed events are never selected again by a poll
+	claimed, err := self.store.saveOwned(ev, self.ownerId, self.seq.Next())
+	if err != nil {
+		return nil, err
+	}
+	self.nbClaimed.Next()
+	return claimed, nil
+}
+
 func (self *MySQLConsumer) Events() <-chan []*Event {
 	return self.eventChan
 }
 
 func (self *MySQLConsumer) GetStat(reset bool) map[string]interface{} {
 	stat := map[string]interface{}{
-		"nbGetEvents": self.seq.Get() - self.lastSeq,
+		"nbGetEvents": self.seq.Get() - self.lastSeq - self.nbClaimed.Get(),
 		"nbRecovered": self.nbRecovered.Get(),
+		"nbFull":      self.nbFull.Get(),
+		"nbIdle":      self.nbIdle.Get(),
+		"nbBurst":     self.nbBurst.Get(),
+		"nbClaimed":   self.nbClaimed.Get(),
+		"nbRebalance": self.nbRebalance.Get(),
 	}
 	if reset {
 		self.nbRecovered.Reset()
+		self.nbFull.Reset()
+		self.nbIdle.Reset()
+		self.nbBurst.Reset()
+		self.nbClaimed.Reset()
+		self.nbRebalance.Reset()
 		self.lastSeq = self.seq.Get()
 	}
 
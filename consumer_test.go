@@ -1,6 +1,7 @@
 package futurama
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"testing"
 	"time"
@@ -73,3 +74,126 @@ func TestConsumer_GetEvents(t *testing.T) {
 		assert.Fail("Did not get event")
 	}
 }
+
+func TestConsumer_AdaptivePolling(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ConsumerSelectLimit = 2
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	store := NewMySQLStore(cfg)
+	consumer := NewMySQLConsumer(cfg, store)
+	store.Open()
+	consumer.Start()
+
+	defer func() {
+		consumer.Stop()
+		store.Close()
+	}()
+
+	// polls are slowed down while there is nothing to claim
+	time.Sleep(2 * time.Second)
+	stat := consumer.GetStat(true)
+	assert := assert.New(t)
+	assert.True(stat["nbIdle"].(int32) <= 6, "too many polls: %d", stat["nbIdle"])
+
+	// full batches are followed by an immediate poll
+	for i := 0; i < 5; i++ {
+		store.Save(NewEvent(Test_TriggerType_Default, time.Now(), nil))
+	}
+	nbEvents := 0
+	for nbEvents < 5 {
+		select {
+		case events := <-consumer.Events():
+			nbEvents += len(events)
+		case <-time.After(2 * time.Second):
+			assert.FailNow("Did not get events")
+		}
+	}
+	stat = consumer.GetStat(true)
+	assert.True(stat["nbBurst"].(int32) >= 1)
+
+	// the next unclaimed event is claimed once it is in the time window,
+	// ids prefixed by the trigger time in seconds are claimed within the following second
+	timeWindow := time.Duration(cfg.ConsumerTimeWindowSec) * time.Second
+	triggerTime := time.Now().Add(timeWindow + 3*time.Second)
+	store.Save(NewEvent(Test_TriggerType_Default, triggerTime, nil))
+	select {
+	case <-consumer.Events():
+		assert.WithinDuration(triggerTime.Add(-timeWindow).Add(600*time.Millisecond), time.Now(), 700*time.Millisecond)
+	case <-time.After(5 * time.Second):
+		assert.Fail("Did not get event")
+	}
+}
+
+func TestConsumer_Partitions(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Partitions = 8
+	cfg.ConsumerHeartbeatSec = 1
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	store := NewMySQLStore(cfg)
+	store.Open()
+	defer store.Close()
+
+	consumers := make([]*MySQLConsumer, 2)
+	for i := range consumers {
+		consumerCfg := *cfg
+		consumerCfg.ConsumerName = fmt.Sprintf("consumer%d", i)
+		consumers[i] = NewMySQLConsumer(&consumerCfg, store)
+		consumers[i].Start()
+	}
+
+	// partitions are divided once both have joined
+	time.Sleep(2500 * time.Millisecond)
+	ring := newHashRing([]string{"consumer0", "consumer1"}, cfg.ConsumerVirtualNodes)
+
+	assert := assert.New(t)
+	receive := func(consumer *MySQLConsumer, nbEvents int) []*Event {
+		var received []*Event
+		for len(received) < nbEvents {
+			select {
+			case events := <-consumer.Events():
+				received = append(received, events...)
+			case <-time.After(3 * time.Second):
+				assert.FailNow("Did not get events", consumer.ownerId)
+			}
+		}
+		return received
+	}
+
+	nbEvents := make(map[string]int)
+	for i := 0; i < 20; i++ {
+		ev := NewEvent(Test_TriggerType_Default, time.Now(), nil)
+		store.Save(ev)
+		nbEvents[ring.owner(partitionOf(ev.Id, cfg.Partitions))]++
+	}
+	// each consumer claims events of its own partitions only
+	for _, consumer := range consumers {
+		for _, ev := range receive(consumer, nbEvents[consumer.ownerId]) {
+			assert.Equal(consumer.ownerId, ring.owner(partitionOf(ev.Id, cfg.Partitions)), ev.Id)
+		}
+	}
+
+	// partitions of a leaving consumer are taken over by the others
+	consumers[1].Stop()
+	time.Sleep(1500 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		store.Save(NewEvent(Test_TriggerType_Default, time.Now(), nil))
+	}
+	receive(consumers[0], 10)
+	assert.True(consumers[0].GetStat(false)["nbRebalance"].(int32) >= 2)
+	consumers[0].Stop()
+
+	// events created with more partitions are claimed once their number is lowered
+	for i := 0; i < 10; i++ {
+		store.Save(NewEvent(Test_TriggerType_Default, time.Now(), nil))
+	}
+	fewerCfg := *cfg
+	fewerCfg.Partitions = 3
+	fewerCfg.ConsumerName = "consumer2"
+	fewer := NewMySQLStore(&fewerCfg)
+	fewer.Open()
+	defer fewer.Close()
+	consumer := NewMySQLConsumer(&fewerCfg, fewer)
+	consumer.Start()
+	defer consumer.Stop()
+	receive(consumer, 10)
+}
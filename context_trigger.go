@@ -0,0 +1,34 @@
+package futurama
+
+import (
+	"context"
+)
+
+// ContextTriggerFunc is a TriggerInterface of a function taking a context, see ContextTriggerInterface
+type ContextTriggerFunc func(ctx context.Context, ev *Event) *TriggerResult
+
+func (self ContextTriggerFunc) Trigger(ev *Event) *TriggerResult {
+	return self(context.Background(), ev)
+}
+
+func (self ContextTriggerFunc) TriggerContext(ctx context.Context, ev *Event) *TriggerResult {
+	return self(ctx, ev)
+}
+
+// WithContext adapts a trigger which does not take a context, triggers already implementing
+// ContextTriggerInterface are returned as is. Trigger can not be interrupted: ctx is ignored, and the event is
+// kept running until Trigger returns, not to be released or retried while running
+func WithContext(trigger TriggerInterface) ContextTriggerInterface {
+	if contextTrigger, ok := trigger.(ContextTriggerInterface); ok {
+		return contextTrigger
+	}
+	return &contextTrigger{trigger}
+}
+
+type contextTrigger struct {
+	trigger TriggerInterface
+}
+
+func (self *contextTrigger) TriggerContext(ctx context.Context, ev *Event) *TriggerResult {
+	return self.trigger.Trigger(ev)
+}
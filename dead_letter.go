@@ -0,0 +1,207 @@
+package futurama
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/golang/glog"
+	"strings"
+	"time"
+)
+
+const SQL_TMPL_CREATE_DEAD_LETTER_TABLE = `CREATE TABLE IF NOT EXISTS %[1]s (
+ id VARCHAR(128) NOT NULL,
+ trigger_type VARCHAR(64),
+ trigger_time DATETIME%[2]s NOT NULL,
+ retry_attempts INT DEFAULT 0,
+ data TEXT,
+ status INT,
+ group_key VARCHAR(128) NOT NULL DEFAULT '',
+ parent_id VARCHAR(128) NOT NULL DEFAULT '',
+ deadline DATETIME%[2]s DEFAULT NULL,
+ cron_spec VARCHAR(128) NOT NULL DEFAULT '',
+ repeat_interval_msec BIGINT NOT NULL DEFAULT 0,
+ max_occurrences INT NOT NULL DEFAULT 0,
+ end_time DATETIME%[2]s DEFAULT NULL,
+ occurrence INT NOT NULL DEFAULT 1,
+ occurrence_time DATETIME%[2]s DEFAULT NULL,
+ follow_ups TEXT,
+ first_attempt DATETIME%[2]s DEFAULT NULL,
+ backoff_policy VARCHAR(64) NOT NULL DEFAULT '',
+ time_created DATETIME%[2]s,
+ last_result TEXT,
+ time_failed DATETIME%[2]s,
+ PRIMARY KEY(id),
+ KEY idx_time_failed(time_failed))`
+
+var (
+	SQL_DEAD_LETTER_EVENT string
+
+	SQL_TMPL_REDRIVE_EVENTS         string
+	SQL_TMPL_DELETE_DEAD_LETTERS    string
+	SQL_TMPL_SELECT_DEAD_LETTERS    string
+	SQL_TMPL_SELECT_DEAD_LETTER_IDS string
+)
+
+// DeadLetter is an event given up after its last attempt
+type DeadLetter struct {
+	*Event
+	// TriggerResult.Data of the last attempt
+	LastResult interface{}
+	Failed     time.Time
+}
+
+func initDeadLetterSQL(cfg *Config) {
+	// copies every column, retry_attempts is replaced with the final attempt count
+	SQL_DEAD_LETTER_EVENT = fmt.Sprintf(`INSERT INTO %s (%s, time_created, last_result, time_failed)
+ SELECT %s, time_created, ?, NOW() FROM %s WHERE id=?`,
+		cfg.DeadLetterTableName, SQL_EVENT_COLUMNS,
+		strings.Replace(SQL_EVENT_COLUMNS, "retry_attempts", "?", 1), cfg.TableName)
+
+	// redriven events are pending again from their first attempt
+	redriveColumns := strings.NewReplacer(
+		"trigger_time", "?",
+		"retry_attempts", "0",
+		"first_attempt", "NULL",
+		"occurrence_time", "NULL",
+		"status", "?",
+	).Replace(SQL_EVENT_COLUMNS)
+	// partitioned as by partitionKey, dead letters have no partition
+	SQL_TMPL_REDRIVE_EVENTS = fmt.Sprintf(`INSERT INTO %s (%s, partition_id, time_created)
+ SELECT %s, CRC32(id), time_created FROM %s WHERE id IN (%%s)`,
+		cfg.TableName, SQL_EVENT_COLUMNS, redriveColumns, cfg.DeadLetterTableName)
+	SQL_TMPL_DELETE_DEAD_LETTERS = fmt.Sprintf(`DELETE FROM %s WHERE id IN (%%s)`, cfg.DeadLetterTableName)
+	SQL_TMPL_SELECT_DEAD_LETTERS = fmt.Sprintf(`SELECT %s, last_result, time_failed
+ FROM %s WHERE %%s ORDER BY time_failed`, SQL_EVENT_COLUMNS, cfg.DeadLetterTableName)
+	SQL_TMPL_SELECT_DEAD_LETTER_IDS = fmt.Sprintf(`SELECT id FROM %s WHERE %%s LIMIT %d`,
+		cfg.DeadLetterTableName, cfg.BulkChunkSize)
+}
+
+// DeadLetter moves ev to the dead letter table, along with saving its follow-up events
+func (self *MySQLStore) DeadLetter(ev *Event, lastResult interface{}, events []*Event) error {
+	glog.Infoln("DeadLetter", ev.Id, ev.Attempts+1)
+	self.nbDeadLetter.Next()
+
+	jsonBytes, _ := Encoder.Marshal(lastResult)
+	strResult := strings.TrimSpace(string(jsonBytes))
+
+	return self.saveInTx(events, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(SQL_DEAD_LETTER_EVENT, ev.Attempts+1, strResult, ev.Id); err != nil {
+			return err
+		}
+		return self.ownedExec(tx, ev, SQL_DELETE_OWNED_EVENT, ev.Id)
+	})
+}
+
+// ListDeadLetters returns dead letters matching filter (all if empty) in the order they failed,
+// limit <= 0 means no limit
+func (self *MySQLStore) ListDeadLetters(filter *EventFilter, limit int) ([]*DeadLetter, error) {
+	cond, args := "1=1", []interface{}{}
+	if filter != nil && !filter.IsEmpty() {
+		cond, args = filterCondition(filter)
+	}
+	query := fmt.Sprintf(SQL_TMPL_SELECT_DEAD_LETTERS, cond)
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	rows, err := self.db.Query(query, args...)
+	if err != nil {
+		glog.Errorln("ListDeadLetters:", err)
+		self.nbError.Next()
+		return nil, err
+	}
+	defer rows.Close()
+
+	deadLetters := make([]*DeadLetter, 0)
+	for rows.Next() {
+		var strResult sql.NullString
+		deadLetter := &DeadLetter{}
+		if deadLetter.Event, err = scanEvent(rows, &strResult, &deadLetter.Failed); err != nil {
+			return nil, err
+		}
+		deadLetter.LastResult = decodeData(strResult.String)
+		deadLetters = append(deadLetters, deadLetter)
+	}
+	return deadLetters, rows.Err()
+}
+
+// Redrive moves a dead letter back to the event table, to be triggered at triggerTime
+func (self *MySQLStore) Redrive(evId string, triggerTime time.Time) error {
+	n, err := self.redrive([]string{evId}, triggerTime)
+	if err == nil && n == 0 {
+		err = fmt.Errorf("Dead letter not found: %s", evId)
+	}
+	return err
+}
+
+// RedriveWhere moves dead letters matching filter back to the event table, BulkChunkSize rows per transaction
+func (self *MySQLStore) RedriveWhere(filter *EventFilter, triggerTime time.Time) (int, error) {
+	glog.Infoln("RedriveWhere", filter, triggerTime)
+	if filter == nil || filter.IsEmpty() {
+		return 0, fmt.Errorf("Empty filter")
+	}
+	cond, args := filterCondition(filter)
+	query := fmt.Sprintf(SQL_TMPL_SELECT_DEAD_LETTER_IDS, cond)
+
+	total := 0
+	for {
+		ids, err := self.selectIds(query, args...)
+		if err != nil {
+			glog.Errorln("RedriveWhere:", err, total)
+			self.nbError.Next()
+			return total, err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		n, err := self.redrive(ids, triggerTime)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if len(ids) < self.cfg.BulkChunkSize {
+			break
+		}
+	}
+	glog.Infoln("RedriveWhere redriven", total)
+	return total, nil
+}
+
+func (self *MySQLStore) redrive(ids []string, triggerTime time.Time) (int, error) {
+	glog.Infoln("Redrive", len(ids), triggerTime)
+	self.nbRedrive.Next()
+
+	var total int64
+	err := self.saveInTx(nil, func(tx *sql.Tx) error {
+		args := append([]interface{}{triggerTime, EventStatus_DEFAULT}, stringArgs(ids)...)
+		res, err := tx.Exec(fmt.Sprintf(SQL_TMPL_REDRIVE_EVENTS, placeholders(len(ids))), args...)
+		if err != nil {
+			return err
+		}
+		total, _ = res.RowsAffected()
+		_, err = tx.Exec(fmt.Sprintf(SQL_TMPL_DELETE_DEAD_LETTERS, placeholders(len(ids))), stringArgs(ids)...)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(total), nil
+}
+
+func (self *MySQLStore) selectIds(query string, args ...interface{}) ([]string, error) {
+	rows, err := self.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,99 @@
+package futurama
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type testTrigger_Failure struct {
+	C chan string
+}
+
+func (self *testTrigger_Failure) Trigger(ev *Event) *TriggerResult {
+	defer func() {
+		self.C <- ev.Id
+	}()
+	if ev.Data == "fixed" {
+		return &TriggerResult{Status: EventStatus_OK}
+	}
+	return &TriggerResult{Status: EventStatus_RETRY, TriggerTime: time.Now(), Data: "downstream error"}
+}
+
+func TestDeadLetter_Redrive(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxRetry = 1
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	c := make(chan string, 64)
+	q, _ := CreateQueue(cfg, map[string]TriggerInterface{
+		Test_TriggerType_Default: &testTrigger_Failure{c},
+		Test_TriggerType_Retry:   &testTrigger_Failure{c},
+	})
+	q.Start()
+	defer q.Stop()
+	assert := assert.New(t)
+
+	triggerTime := time.Now().Add(time.Second)
+	evId1 := q.Create(Test_TriggerType_Default, triggerTime, "")
+	evId2 := q.Create(Test_TriggerType_Retry, triggerTime, "fixed")
+	ev := NewEvent(Test_TriggerType_Retry, triggerTime, "")
+	ev.GroupKey = "player:1"
+	evId3 := q.CreateEvent(ev)
+
+	for i := 0; i < 5; i++ {
+		select {
+		case <-c:
+		case <-time.After(2 * time.Second):
+			assert.Fail("event is not triggered")
+		}
+	}
+	time.Sleep(500 * time.Millisecond)
+	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 0)
+
+	deadLetters, err := q.ListDeadLetters(nil, 0)
+	assert.NoError(err)
+	assert.Len(deadLetters, 2)
+	for _, deadLetter := range deadLetters {
+		assert.NotEqual(evId2, deadLetter.Id)
+		assert.Equal(2, deadLetter.Attempts)
+		assert.Equal("downstream error", deadLetter.LastResult)
+		assert.WithinDuration(time.Now(), deadLetter.Failed, 2*time.Second)
+	}
+	deadLetters, err = q.ListDeadLetters(&EventFilter{GroupKey: "player:1"}, 0)
+	assert.NoError(err)
+	assert.Len(deadLetters, 1)
+	assert.Equal(evId3, deadLetters[0].Id)
+	assert.Equal("player:1", deadLetters[0].GroupKey)
+
+	assert.Error(q.Redrive("unknown_id", time.Now()))
+	_, err = q.RedriveWhere(&EventFilter{}, time.Now())
+	assert.Error(err)
+
+	redriveTime := time.Now().Add(time.Second)
+	assert.NoError(q.Redrive(evId1, redriveTime))
+	n, err := q.RedriveWhere(&EventFilter{TriggerType: Test_TriggerType_Retry}, redriveTime)
+	assert.NoError(err)
+	assert.Equal(1, n)
+
+	deadLetters, _ = q.ListDeadLetters(nil, 0)
+	assert.Len(deadLetters, 0)
+	evList := TestOnly_SelectEvents(&cfg.MySQLConfig)
+	assert.Len(evList, 2)
+	for _, ev := range evList {
+		assert.Equal(0, ev.Attempts)
+		assert.Equal(int(ev.Status), EventStatus_DEFAULT)
+		assert.WithinDuration(redriveTime, ev.TriggerTime, 50*time.Millisecond)
+	}
+
+	triggered := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		select {
+		case id := <-c:
+			triggered[id] = true
+		case <-time.After(2 * time.Second):
+			assert.Fail("redriven event is not triggered")
+		}
+	}
+	assert.True(triggered[evId1])
+	assert.True(triggered[evId3])
+}
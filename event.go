@@ -11,6 +11,9 @@ const (
 	EventStatus_CANCEL
 	EventStatus_ERROR
 	EventStatus_RETRY
+	EventStatus_EXPIRED
+	EventStatus_SKIPPED
+	EventStatus_PENDING
 )
 
 var eventStatusText = []string{
@@ -20,6 +23,9 @@ var eventStatusText = []string{
 	"CANCEL",
 	"ERROR",
 	"RETRY",
+	"EXPIRED",
+	"SKIPPED",
+	"PENDING",
 }
 
 type EventStatus uint32
@@ -38,13 +44,51 @@ type Event struct {
 	Owner       string
 	Attempts    int
 	Status      EventStatus
+	GroupKey    string
+	ParentId    string
 	Created     time.Time
 	Updated     time.Time
 	Completed   time.Time
 	Locked      time.Time
 	Data        interface{}
 
+	// sequence of the consumer poll which has taken ev for Owner, fencing writes of Owner
+	OwnerSeq int32
+	// time of the first failed attempt of the current retries, zero if it has not been retried
+	FirstAttempt time.Time
+	// name of a policy registered by Queue.RegisterBackoffPolicy delaying retries,
+	// the policy of the trigger type is used if empty
+	BackoffPolicy string
+
+	// if not zero, the event is completed as EXPIRED instead of being triggered after Deadline
+	Deadline time.Time
+
+	// recurring events are triggered either on CronSpec or every Interval,
+	// until MaxOccurrences (if > 0) occurrences have been triggered or EndTime (if not zero) is passed
+	CronSpec       string
+	Interval       time.Duration
+	MaxOccurrences int
+	EndTime        time.Time
+	// 1 for the first occurrence
+	Occurrence int
+	// scheduled time of the current occurrence once TriggerTime has been moved by a retry or an ack deadline,
+	// zero if TriggerTime is
+	OccurrenceTime time.Time
+
+	// follow-up events created when this one is completed with status OK or ERROR
+	OnSuccess *FollowUp
+	OnFailure *FollowUp
+
+	// ids of events whose ParentId is this one, only filled by Queue.Get
+	Children []string
+
 	timer *time.Timer
+	// when its timer fired, before waiting for a worker
+	fired time.Time
+	// delayed by the rate limit of its trigger type, with a token reserved
+	throttled bool
+	// added by the trigger of the current attempt, see Chain
+	chained []*Event
 }
 
 func NewEvent(triggerType string, triggerTime time.Time, data interface{}) *Event {
@@ -54,17 +98,106 @@ func NewEvent(triggerType string, triggerTime time.Time, data interface{}) *Even
 		Status:      EventStatus_DEFAULT,
 		Created:     time.Now(),
 		Data:        data,
+		Occurrence:  1,
 	}
 }
 func (self *Event) String() string {
 	return fmt.Sprintf("%s %d", self.Id, self.TriggerTime.Unix())
 }
 
+func (self *Event) IsExpired(t time.Time) bool {
+	return !self.Deadline.IsZero() && t.After(self.Deadline)
+}
+
+func (self *Event) IsRecurring() bool {
+	return self.CronSpec != "" || self.Interval > 0
+}
+
+// reschedule moves the current attempt to triggerTime, keeping the scheduled time of the occurrence
+func (self *Event) reschedule(triggerTime time.Time) {
+	if self.OccurrenceTime.IsZero() {
+		self.OccurrenceTime = self.TriggerTime
+	}
+	self.TriggerTime = triggerTime
+}
+
+// occurrenceTime returns the scheduled time of the current occurrence
+func (self *Event) occurrenceTime() time.Time {
+	if self.OccurrenceTime.IsZero() {
+		return self.TriggerTime
+	}
+	return self.OccurrenceTime
+}
+
 func (self *Event) Stop() {
-	self.timer.Stop()
-	self.timer = nil
+	if self.timer != nil {
+		self.timer.Stop()
+		self.timer = nil
+	}
+}
+
+// Chain adds events to be created along with the completion of the current attempt, in the same transaction
+// if the store supports it. Called from the trigger of the event, events are dropped if it is not completed
+// (retried, left pending or interrupted)
+func (self *Event) Chain(events ...*Event) {
+	self.chained = append(self.chained, events...)
 }
 
 func (self *Event) GetKey() string {
 	return self.Id
 }
+
+// FollowUp describes an event created when its parent is completed, triggered Delay after the completion,
+// ParentId of the created event is the completed one.
+// Follow-ups can have their own follow-ups so that a multi-step flow can be declared at once.
+type FollowUp struct {
+	TriggerType string
+	Delay       time.Duration
+	Data        interface{}
+	// parent's GroupKey if empty
+	GroupKey string
+
+	OnSuccess *FollowUp
+	OnFailure *FollowUp
+}
+
+func (self *FollowUp) NewEvent(parent *Event, now time.Time) *Event {
+	ev := NewEvent(self.TriggerType, now.Add(self.Delay), self.Data)
+	ev.GroupKey = self.GroupKey
+	if ev.GroupKey == "" {
+		ev.GroupKey = parent.GroupKey
+	}
+	ev.ParentId = parent.Id
+	ev.OnSuccess = self.OnSuccess
+	ev.OnFailure = self.OnFailure
+	return ev
+}
+
+// followUps returns events to be created when ev is completed with status
+func (self *Event) followUps(status EventStatus, now time.Time) []*Event {
+	var followUp *FollowUp
+	switch status {
+	case EventStatus_OK:
+		followUp = self.OnSuccess
+	case EventStatus_ERROR:
+		followUp = self.OnFailure
+	}
+	if followUp == nil {
+		return nil
+	}
+	return []*Event{followUp.NewEvent(self, now)}
+}
+
+// EventFilter selects pending events, zero valued fields are ignored.
+// TriggerTime range is [From, To)
+type EventFilter struct {
+	TriggerType string
+	// the tag of events, Event.GroupKey
+	GroupKey string
+	From     time.Time
+	To       time.Time
+}
+
+func (self *EventFilter) IsEmpty() bool {
+	return self.TriggerType == "" && self.GroupKey == "" && self.From.IsZero() && self.To.IsZero()
+}
@@ -0,0 +1,100 @@
+package futurama
+
+import (
+	"time"
+)
+
+// scheduler implementations, SchedulerConfig.SchedulerType
+const (
+	SchedulerType_HEAP  = "heap"  // a timer per event, ordered by a heap
+	SchedulerType_WHEEL = "wheel" // a hierarchical timing wheel driven by a single ticker
+)
+
+// eventTimers keeps scheduled events and calls fire for each of them at its time, in its own goroutine.
+// Fired events are kept until removed. Implementations are not safe for concurrent use,
+// the scheduler calls them under eventMutex
+type eventTimers interface {
+	// schedule adds ev to be fired at (at once if passed). When full, the event with the latest trigger time
+	// is evicted to make room for ev and returned, ev itself if it is the latest
+	schedule(ev *Event, at time.Time) (evicted *Event)
+	get(evId string) *Event
+	// remove unschedules an event, nil if it is not scheduled
+	remove(evId string) *Event
+	events() []*Event
+	len() int
+	capacity() int
+	start()
+	stop()
+}
+
+func newEventTimers(cfg *SchedulerConfig, fire func(ev *Event)) eventTimers {
+	if cfg.SchedulerType == SchedulerType_WHEEL {
+		return newTimingWheel(cfg.MaxScheduledEvents, time.Duration(cfg.WheelTickMSec)*time.Millisecond, fire)
+	}
+	return &heapTimers{NewPQ(true, cfg.MaxScheduledEvents), fire}
+}
+
+// heapTimers is a priority queue of events by trigger time, each with its own timer
+type heapTimers struct {
+	pq   *PQ
+	fire func(ev *Event)
+}
+
+func (self *heapTimers) schedule(ev *Event, at time.Time) *Event {
+	index, poped := self.pq.Push(ev, ev.TriggerTime.UnixNano())
+	if index >= 0 {
+		du := at.Sub(time.Now())
+		if du < 0 {
+			du = 0
+		}
+		ev.timer = time.AfterFunc(du, func() {
+			self.fire(ev)
+		})
+	}
+	if poped != nil {
+		popedEv := poped.(*Event)
+		popedEv.Stop()
+		return popedEv
+	}
+	if index < 0 {
+		return ev
+	}
+	return nil
+}
+
+func (self *heapTimers) get(evId string) *Event {
+	if item := self.pq.Get(evId); item != nil {
+		return item.(*Event)
+	}
+	return nil
+}
+
+func (self *heapTimers) remove(evId string) *Event {
+	if removed := self.pq.Remove(evId); removed != nil {
+		removedEv := removed.(*Event)
+		removedEv.Stop()
+		return removedEv
+	}
+	return nil
+}
+
+func (self *heapTimers) events() []*Event {
+	items := self.pq.Items()
+	events := make([]*Event, len(items))
+	for i, item := range items {
+		events[i] = item.(*Event)
+	}
+	return events
+}
+
+func (self *heapTimers) len() int {
+	return self.pq.Len()
+}
+
+func (self *heapTimers) capacity() int {
+	return self.pq.maxSize
+}
+
+func (self *heapTimers) start() {}
+
+func (self *heapTimers) stop() {}
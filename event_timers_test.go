@@ -0,0 +1,179 @@
+package futurama
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func testEventTimers(schedulerType string, maxSize int, fire func(ev *Event)) eventTimers {
+	cfg := DefaultConfig().SchedulerConfig
+	cfg.SchedulerType = schedulerType
+	cfg.MaxScheduledEvents = maxSize
+	cfg.WheelTickMSec = 1
+	return newEventTimers(&cfg, fire)
+}
+
+func TestEventTimers_Fire(t *testing.T) {
+	for _, schedulerType := range []string{SchedulerType_HEAP, SchedulerType_WHEEL} {
+		assert := assert.New(t)
+		fired := make(chan *Event, 64)
+		timers := testEventTimers(schedulerType, 10, func(ev *Event) {
+			fired <- ev
+		})
+		timers.start()
+
+		now := time.Now()
+		// within the first level of the wheel, cascaded from upper levels, and late
+		delays := []time.Duration{30 * time.Millisecond, 150 * time.Millisecond, 400 * time.Millisecond, -time.Second}
+		for i, delay := range delays {
+			ev := NewEvent(Test_TriggerType_Default, now.Add(delay), nil)
+			ev.Id = fmt.Sprintf("%d_%s", i, schedulerType)
+			assert.Nil(timers.schedule(ev, ev.TriggerTime))
+		}
+		cancelled := NewEvent(Test_TriggerType_Default, now.Add(100*time.Millisecond), nil)
+		cancelled.Id = "cancelled"
+		timers.schedule(cancelled, cancelled.TriggerTime)
+		assert.Equal(cancelled, timers.remove("cancelled"))
+		assert.Nil(timers.remove("cancelled"))
+		assert.Equal(4, timers.len())
+
+		for _, i := range []int{3, 0, 1, 2} {
+			select {
+			case ev := <-fired:
+				assert.Equal(fmt.Sprintf("%d_%s", i, schedulerType), ev.Id, schedulerType)
+				if delay := delays[i]; delay > 0 {
+					assert.False(time.Now().Before(now.Add(delay)), schedulerType)
+					assert.WithinDuration(now.Add(delay), time.Now(), 20*time.Millisecond, schedulerType)
+				}
+				// kept until removed by the scheduler
+				assert.Equal(ev, timers.get(ev.Id))
+				timers.remove(ev.Id)
+			case <-time.After(time.Second):
+				assert.FailNow("event is not fired", schedulerType)
+			}
+		}
+		assert.Equal(0, timers.len())
+		timers.stop()
+	}
+}
+
+func TestEventTimers_Evict(t *testing.T) {
+	for _, schedulerType := range []string{SchedulerType_HEAP, SchedulerType_WHEEL} {
+		assert := assert.New(t)
+		timers := testEventTimers(schedulerType, 2, func(ev *Event) {})
+
+		now := time.Now()
+		events := make([]*Event, 4)
+		for i, delay := range []time.Duration{2, 3, 1, 4} {
+			events[i] = NewEvent(Test_TriggerType_Default, now.Add(delay*time.Hour), nil)
+			events[i].Id = fmt.Sprintf("%d", i)
+		}
+		assert.Nil(timers.schedule(events[0], events[0].TriggerTime))
+		assert.Nil(timers.schedule(events[1], events[1].TriggerTime))
+		// the latest one makes room for an earlier one
+		assert.Equal(events[1], timers.schedule(events[2], events[2].TriggerTime), schedulerType)
+		// a later one is not scheduled
+		assert.Equal(events[3], timers.schedule(events[3], events[3].TriggerTime), schedulerType)
+		assert.Equal(2, timers.len())
+		assert.Equal(2, timers.capacity())
+		assert.Nil(timers.get("1"))
+		assert.NotNil(timers.get("2"))
+	}
+}
+
+func TestTimingWheel_Span(t *testing.T) {
+	assert := assert.New(t)
+	var fired []string
+	wheel := newTimingWheel(10, time.Millisecond, func(ev *Event) {
+		fired = append(fired, ev.Id)
+	})
+
+	// expiries beyond the span of the wheel are cascaded until they fit
+	ticks := []int64{1, wheelSize, wheelSize*wheelSize + 5, 1 << (wheelBits * wheelLevels), 1<<(wheelBits*wheelLevels) + 3}
+	for i, tick := range ticks {
+		ev := NewEvent(Test_TriggerType_Default, time.Now(), nil)
+		ev.Id = fmt.Sprintf("%d", i)
+		entry := &wheelEntry{ev: ev, expiry: tick}
+		wheel.entries[ev.Id] = entry
+		wheel.place(entry, wheel.current+1)
+	}
+	process := func(tick int64) []string {
+		wheel.current = tick
+		var ids []string
+		for _, ev := range wheel.process(tick, nil) {
+			ids = append(ids, ev.Id)
+		}
+		return ids
+	}
+	for tick := int64(1); tick <= ticks[2]; tick++ {
+		switch tick {
+		case ticks[0]:
+			assert.Equal([]string{"0"}, process(tick))
+		case ticks[1]:
+			assert.Equal([]string{"1"}, process(tick))
+		case ticks[2]:
+			assert.Equal([]string{"2"}, process(tick))
+		default:
+			assert.Empty(process(tick), "tick %d", tick)
+		}
+	}
+	// the last ones are kept in the upper level until their slot is cascaded
+	assert.Empty(process(ticks[3] - 1))
+	assert.Equal([]string{"3"}, process(ticks[3]))
+	assert.Empty(process(ticks[4] - 1))
+	assert.Equal([]string{"4"}, process(ticks[4]))
+	assert.Empty(fired)
+}
+
+func benchmarkEventTimers(b *testing.B, schedulerType string, nbScheduled int) {
+	timers := testEventTimers(schedulerType, nbScheduled+b.N, func(ev *Event) {})
+	timers.start()
+	defer timers.stop()
+
+	now := time.Now()
+	newEvent := func(i int) *Event {
+		ev := NewEvent(Test_TriggerType_Default, now.Add(time.Hour+time.Duration(rand.Int63n(int64(time.Hour)))), nil)
+		ev.Id = fmt.Sprintf("%d", i)
+		return ev
+	}
+	for i := 0; i < nbScheduled; i++ {
+		ev := newEvent(i)
+		timers.schedule(ev, ev.TriggerTime)
+	}
+	events := make([]*Event, b.N)
+	for i := range events {
+		events[i] = newEvent(nbScheduled + i)
+	}
+
+	b.ResetTimer()
+	for _, ev := range events {
+		timers.schedule(ev, ev.TriggerTime)
+	}
+	for _, ev := range events {
+		timers.remove(ev.Id)
+	}
+	b.StopTimer()
+
+	for _, ev := range timers.events() {
+		timers.remove(ev.Id)
+	}
+}
+
+func BenchmarkEventTimers_Heap(b *testing.B) {
+	benchmarkEventTimers(b, SchedulerType_HEAP, 0)
+}
+
+func BenchmarkEventTimers_Wheel(b *testing.B) {
+	benchmarkEventTimers(b, SchedulerType_WHEEL, 0)
+}
+
+func BenchmarkEventTimers_Heap_1M(b *testing.B) {
+	benchmarkEventTimers(b, SchedulerType_HEAP, 1000000)
+}
+
+func BenchmarkEventTimers_Wheel_1M(b *testing.B) {
+	benchmarkEventTimers(b, SchedulerType_WHEEL, 1000000)
+}
@@ -36,6 +36,11 @@ func (self *NoStore) UpdateForRetry(ev *futurama.Event, retryParan interface{})
 	return nil
 }
 
+func (self *NoStore) UpdateForNext(ev *futurama.Event) error {
+	self.eventChan <- []*futurama.Event{ev}
+	return nil
+}
+
 func (self *NoStore) GetStat(reset bool) map[string]interface{} {
 	return map[string]interface{}{}
 }
@@ -0,0 +1,87 @@
+package futurama
+
+import (
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// hashRing divides partitions between members by consistent hashing: each member has replicas points on a ring
+// of crc32 hashes, a partition belongs to the member of the first point at or after its own hash.
+// A member joining or leaving only moves the partitions next to its points
+type hashRing struct {
+	points  uint32Slice
+	members map[uint32]string
+}
+
+type uint32Slice []uint32
+
+func (self uint32Slice) Len() int           { return len(self) }
+func (self uint32Slice) Less(i, j int) bool { return self[i] < self[j] }
+func (self uint32Slice) Swap(i, j int)      { self[i], self[j] = self[j], self[i] }
+
+func newHashRing(members []string, replicas int) *hashRing {
+	if replicas <= 0 {
+		replicas = 1
+	}
+	sorted := append([]string(nil), members...)
+	sort.Strings(sorted)
+
+	ring := &hashRing{
+		members: make(map[uint32]string),
+	}
+	for _, member := range sorted {
+		for i := 0; i < replicas; i++ {
+			point := crc32.ChecksumIEEE([]byte(fmt.Sprintf("%s#%d", member, i)))
+			// colliding points go to the first member in order, whatever the order of members
+			if _, ok := ring.members[point]; ok {
+				continue
+			}
+			ring.members[point] = member
+			ring.points = append(ring.points, point)
+		}
+	}
+	sort.Sort(ring.points)
+	return ring
+}
+
+// owner returns the member owning partition, empty if the ring has no member
+func (self *hashRing) owner(partition int) string {
+	if len(self.points) == 0 {
+		return ""
+	}
+	hash := crc32.ChecksumIEEE([]byte(strconv.Itoa(partition)))
+	i := sort.Search(len(self.points), func(i int) bool {
+		return self.points[i] >= hash
+	})
+	if i == len(self.points) {
+		i = 0
+	}
+	return self.members[self.points[i]]
+}
+
+// partitions returns the partitions out of nbPartitions owned by member
+func (self *hashRing) partitions(member string, nbPartitions int) []int {
+	var partitions []int
+	for partition := 0; partition < nbPartitions; partition++ {
+		if self.owner(partition) == member {
+			partitions = append(partitions, partition)
+		}
+	}
+	return partitions
+}
+
+// partitionKey returns the hash of an event id kept as its partition_id, CRC32(id) in MySQL.
+// Events are matched to partitions by MOD(partition_id, nbPartitions), so that the number of partitions can change
+func partitionKey(evId string) uint32 {
+	return crc32.ChecksumIEEE([]byte(evId))
+}
+
+// partitionOf returns the partition of an event id out of nbPartitions, as MOD(partition_id, nbPartitions) in MySQL
+func partitionOf(evId string, nbPartitions int) int {
+	if nbPartitions <= 0 {
+		return 0
+	}
+	return int(partitionKey(evId) % uint32(nbPartitions))
+}
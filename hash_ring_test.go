@@ -0,0 +1,76 @@
+package futurama
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func ringPartitions(ring *hashRing, members []string, nbPartitions int) map[string][]int {
+	partitions := make(map[string][]int)
+	for _, member := range members {
+		partitions[member] = ring.partitions(member, nbPartitions)
+	}
+	return partitions
+}
+
+func TestHashRing_Partitions(t *testing.T) {
+	assert := assert.New(t)
+	members := []string{"c", "a", "b", "d"}
+	ring := newHashRing(members, 64)
+
+	// every partition belongs to a single member, whatever the order of members
+	owned := make(map[int]string)
+	for member, partitions := range ringPartitions(ring, members, 256) {
+		assert.NotEmpty(partitions, member)
+		for _, partition := range partitions {
+			assert.Empty(owned[partition], "partition %d", partition)
+			owned[partition] = member
+		}
+	}
+	assert.Len(owned, 256)
+	assert.Equal(ringPartitions(ring, members, 256), ringPartitions(newHashRing([]string{"d", "b", "a", "c"}, 64), members, 256))
+
+	assert.Equal("", newHashRing(nil, 64).owner(0))
+	assert.Empty(newHashRing(nil, 64).partitions("a", 256))
+}
+
+func TestHashRing_Rebalance(t *testing.T) {
+	assert := assert.New(t)
+	members := []string{"a", "b", "c"}
+	ring := newHashRing(members, 64)
+
+	// only partitions taken by the joining member move
+	joined := newHashRing(append(members, "d"), 64)
+	nbMoved := 0
+	for partition := 0; partition < 256; partition++ {
+		if owner := joined.owner(partition); owner != ring.owner(partition) {
+			assert.Equal("d", owner, "partition %d", partition)
+			nbMoved++
+		}
+	}
+	assert.True(nbMoved > 0)
+
+	// only partitions of the leaving member move
+	left := newHashRing([]string{"a", "c"}, 64)
+	for partition := 0; partition < 256; partition++ {
+		if owner := ring.owner(partition); owner != "b" {
+			assert.Equal(owner, left.owner(partition), "partition %d", partition)
+		}
+	}
+}
+
+func TestHashRing_PartitionOf(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(0, partitionOf("1500000000_id", 0))
+	counts := make([]int, 8)
+	for i := 0; i < 8000; i++ {
+		counts[partitionOf(fmt.Sprintf("1500000000_%d", i), 8)]++
+	}
+	for partition, count := range counts {
+		assert.InDelta(1000, count, 200, "partition %d", partition)
+	}
+	// MOD(CRC32('1500000000_id'), 8) in MySQL, as partitions of events saved or redriven
+	assert.Equal(5, partitionOf("1500000000_id", 8))
+	assert.Equal(5, int(partitionKey("1500000000_id")%8))
+}
@@ -3,24 +3,9 @@ package futurama
 import (
 	"bytes"
 	"encoding/json"
-	"math"
-	"math/rand"
 	"sync"
-	"time"
 )
 
-func backoff(attempt int) time.Time {
-	const RETRY_BACKOFF_CAP = 10 * time.Minute
-
-	basePart := int(math.Pow(2, float64(attempt-2)) * 1000)
-	randPart := rand.Intn(basePart)
-	du := time.Duration(basePart+randPart) * time.Millisecond
-	if du > RETRY_BACKOFF_CAP {
-		du = RETRY_BACKOFF_CAP
-	}
-	return time.Now().Add(du)
-}
-
 type reusableEncoder struct {
 	buf     *bytes.Buffer
 	encoder *json.Encoder
@@ -5,7 +5,6 @@ import (
 	"github.com/stretchr/testify/assert"
 	"strings"
 	"testing"
-	"time"
 )
 
 func TestHelper_EncoderPool_bufferOverwritten(t *testing.T) {
@@ -42,10 +41,9 @@ func TestHelper_Backoff(t *testing.T) {
 		600000, 600000, 600000,
 	}
 
+	policy := DefaultConfig().TriggerTypeDefault.backoffPolicy()
 	for i := 0; i < 20; i++ {
-		now := time.Now()
-		b := backoff(i)
-		du := b.Sub(now)
+		du := policy.Backoff(i)
 		glog.Infoln(du)
 		assert.InDelta(t, du.Seconds()*1000, expected[i], 2*expected[i])
 	}
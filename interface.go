@@ -1,6 +1,9 @@
 package futurama
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 type StatInterface interface {
 	GetStat(reset bool) map[string]interface{}
@@ -13,6 +16,75 @@ type StoreInterface interface {
 	Cancel(evId string) error
 	UpdateStatus(evId string, status EventStatus) error
 	UpdateForRetry(ev *Event, retryParam interface{}) error
+	UpdateForNext(ev *Event) error
+}
+
+// optional, implemented by stores which can operate on many events at once
+type FilterStoreInterface interface {
+	CancelWhere(filter *EventFilter) (int, error)
+	ListWhere(filter *EventFilter, limit int) ([]*Event, error)
+	CountWhere(filter *EventFilter) (int, error)
+}
+
+// optional, implemented by stores which support cluster-wide jobs
+type JobStoreInterface interface {
+	// SaveJob creates ev with its given id, or updates the already created one
+	SaveJob(ev *Event) error
+}
+
+// optional, implemented by stores which can save follow-up events in the same transaction completing an event,
+// otherwise follow-ups are saved after the completion
+type ChainStoreInterface interface {
+	UpdateStatusAndSave(ev *Event, status EventStatus, events []*Event) error
+	UpdateForNextAndSave(ev *Event, events []*Event) error
+}
+
+// optional, implemented by stores which can tell cancelled events, running triggers of events cancelled
+// by CancelWhere are interrupted
+type CancelledStoreInterface interface {
+	// Cancelled returns the ids out of evIds whose events have been cancelled
+	Cancelled(evIds []string) ([]string, error)
+}
+
+// optional, implemented by stores which keep ParentId of events
+type TreeStoreInterface interface {
+	// Get returns an event whatever its status, e.g. cancelled but not deleted yet, with ids of its children
+	Get(evId string) (*Event, error)
+	// CancelCascade cancels an event and all its descendants at once, returns ids of the cancelled events
+	CancelCascade(evId string) ([]string, error)
+}
+
+// optional, implemented by stores which keep given up events
+type DeadLetterStoreInterface interface {
+	// DeadLetter moves ev to dead letters, along with saving its follow-up events
+	DeadLetter(ev *Event, lastResult interface{}, events []*Event) error
+	ListDeadLetters(filter *EventFilter, limit int) ([]*DeadLetter, error)
+	Redrive(evId string, triggerTime time.Time) error
+	RedriveWhere(filter *EventFilter, triggerTime time.Time) (int, error)
+}
+
+// optional, implemented by stores which can keep events triggered with EventStatus_PENDING until Queue.Ack
+type AckStoreInterface interface {
+	// UpdateForAck releases ev as PENDING until its ack deadline ev.TriggerTime
+	UpdateForAck(ev *Event, retryParam interface{}) error
+	// Ack takes a PENDING event to be completed, nil if the event is not pending anymore
+	Ack(evId string) (*Event, error)
+}
+
+// optional, implemented by stores which fence writes by ownership, writes of an event which has been
+// taken by another consumer since ev.Owner took it return ErrLostOwnership. UpdateForRetry, UpdateForNext
+// and the writes of ChainStoreInterface, DeadLetterStoreInterface and AckStoreInterface are fenced as well
+type OwnedStoreInterface interface {
+	// UpdateOwnedStatus completes ev if it is still owned
+	UpdateOwnedStatus(ev *Event, status EventStatus) error
+}
+
+// optional, implemented by stores whose events are owned by consumers for a limited time
+type LeaseStoreInterface interface {
+	// RenewOwnership extends ownership of events still owned by owner, returns the number of renewed events
+	RenewOwnership(owner string, evIds []string) (int, error)
+	// ReleaseOwnership gives up events still owned by owner to any consumer, returns the number of released events
+	ReleaseOwnership(owner string, evIds []string) (int, error)
 }
 
 type ConsumerInterface interface {
@@ -21,6 +93,19 @@ type ConsumerInterface interface {
 	Events() <-chan []*Event
 }
 
+// optional, implemented by consumers which claim no more events than the scheduler can take
+type CapacityConsumerInterface interface {
+	// SetCapacity gives the number of events which can be scheduled without evicting others
+	SetCapacity(capacity func() int)
+}
+
+// optional, implemented by consumers which can claim events created by the same instance
+type ClaimConsumerInterface interface {
+	// Claim saves ev already claimed if it would be claimed by the next poll, returns the event to be scheduled
+	// at once, nil if not claimed (ev is not saved then)
+	Claim(ev *Event) (*Event, error)
+}
+
 type TriggerResult struct {
 	Status      EventStatus
 	TriggerTime time.Time
@@ -30,3 +115,10 @@ type TriggerResult struct {
 type TriggerInterface interface {
 	Trigger(ev *Event) *TriggerResult
 }
+
+// optional, implemented by triggers which can be interrupted, TriggerContext is called instead of Trigger.
+// ctx is done after TriggerTypeConfig.TimeoutMSec (the event is retried), on cancel of the event
+// (the event is cancelled) and on Queue.Stop (the event is left to be triggered again)
+type ContextTriggerInterface interface {
+	TriggerContext(ctx context.Context, ev *Event) *TriggerResult
+}
@@ -0,0 +1,67 @@
+package futurama
+
+import (
+	"context"
+	"github.com/golang/glog"
+	"runtime/debug"
+	"time"
+)
+
+// TriggerMiddleware wraps the trigger of every trigger type, see Queue.Use
+type TriggerMiddleware func(next ContextTriggerInterface) ContextTriggerInterface
+
+// LoggingMiddleware logs every trigger with its result
+func LoggingMiddleware() TriggerMiddleware {
+	return func(next ContextTriggerInterface) ContextTriggerInterface {
+		return ContextTriggerFunc(func(ctx context.Context, ev *Event) *TriggerResult {
+			glog.Infof("%s Trigger %s attempts: %d", ev, ev.TriggerType, ev.Attempts)
+			result := next.TriggerContext(ctx, ev)
+			if result != nil {
+				glog.Infof("%s Triggered %s status: %s", ev, ev.TriggerType, result.Status)
+			}
+			return result
+		})
+	}
+}
+
+// RecoveryMiddleware turns panics of triggers into results of the given status,
+// e.g. EventStatus_RETRY for triggers failing temporarily
+func RecoveryMiddleware(status EventStatus) TriggerMiddleware {
+	return func(next ContextTriggerInterface) ContextTriggerInterface {
+		return ContextTriggerFunc(func(ctx context.Context, ev *Event) (result *TriggerResult) {
+			defer func() {
+				if r := recover(); r != nil {
+					glog.Errorf("Recovered in trigger, msg: %s ev: %s stack: %s", r, ev, debug.Stack())
+					result = &TriggerResult{Status: status}
+				}
+			}()
+			return next.TriggerContext(ctx, ev)
+		})
+	}
+}
+
+// TimingMiddleware calls observe after every trigger, e.g. to feed metrics or tracing
+func TimingMiddleware(observe func(ev *Event, result *TriggerResult, took time.Duration)) TriggerMiddleware {
+	return func(next ContextTriggerInterface) ContextTriggerInterface {
+		return ContextTriggerFunc(func(ctx context.Context, ev *Event) *TriggerResult {
+			before := time.Now()
+			result := next.TriggerContext(ctx, ev)
+			observe(ev, result, time.Since(before))
+			return result
+		})
+	}
+}
+
+// ValidationMiddleware completes events as EventStatus_ERROR without triggering them
+// if validate returns an error, e.g. on unexpected ev.Data
+func ValidationMiddleware(validate func(ev *Event) error) TriggerMiddleware {
+	return func(next ContextTriggerInterface) ContextTriggerInterface {
+		return ContextTriggerFunc(func(ctx context.Context, ev *Event) *TriggerResult {
+			if err := validate(ev); err != nil {
+				glog.Warningf("%s Invalid event: %s", ev, err)
+				return &TriggerResult{Status: EventStatus_ERROR, Data: err.Error()}
+			}
+			return next.TriggerContext(ctx, ev)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package futurama
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMiddleware_Chain(t *testing.T) {
+	assert := assert.New(t)
+	c := make(chan string, 64)
+	scheduler := newScheduler(DefaultConfig(), map[string]TriggerInterface{
+		Test_TriggerType_Default: &TestTrigger_Schedule{c},
+		Test_TriggerType_Panic:   &TestTrigger_Panic{},
+	})
+
+	var calls []string
+	tag := func(name string) TriggerMiddleware {
+		return func(next ContextTriggerInterface) ContextTriggerInterface {
+			return ContextTriggerFunc(func(ctx context.Context, ev *Event) *TriggerResult {
+				calls = append(calls, name)
+				return next.TriggerContext(ctx, ev)
+			})
+		}
+	}
+	var observed []EventStatus
+	scheduler.use(tag("first"), LoggingMiddleware(), RecoveryMiddleware(EventStatus_RETRY))
+	scheduler.use(TimingMiddleware(func(ev *Event, result *TriggerResult, took time.Duration) {
+		observed = append(observed, result.Status)
+	}), ValidationMiddleware(func(ev *Event) error {
+		if ev.Data == "invalid" {
+			return fmt.Errorf("Invalid data")
+		}
+		return nil
+	}), tag("last"))
+
+	ev := NewEvent(Test_TriggerType_Default, time.Now(), "")
+	ev.Id = "1_test"
+	result := scheduler.getTrigger(ev.TriggerType).TriggerContext(context.Background(), ev)
+	assert.EqualValues(EventStatus_OK, result.Status)
+	assert.Equal("1_test", <-c)
+	assert.Equal([]string{"first", "last"}, calls)
+
+	ev.Data = "invalid"
+	result = scheduler.getTrigger(ev.TriggerType).TriggerContext(context.Background(), ev)
+	assert.EqualValues(EventStatus_ERROR, result.Status)
+	assert.Equal("Invalid data", result.Data)
+	assert.Equal([]string{"first", "last", "first"}, calls)
+
+	ev = NewEvent(Test_TriggerType_Panic, time.Now(), "")
+	result = scheduler.getTrigger(ev.TriggerType).TriggerContext(context.Background(), ev)
+	assert.EqualValues(EventStatus_RETRY, result.Status)
+
+	// unknown trigger types are wrapped as well
+	ev = NewEvent("unknown", time.Now(), "")
+	result = scheduler.getTrigger(ev.TriggerType).TriggerContext(context.Background(), ev)
+	assert.EqualValues(EventStatus_ERROR, result.Status)
+
+	// the panic is not observed by the inner TimingMiddleware
+	assert.Equal([]EventStatus{EventStatus_OK, EventStatus_ERROR, EventStatus_ERROR}, observed)
+}
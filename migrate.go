@@ -0,0 +1,125 @@
+package futurama
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/golang/glog"
+	"strings"
+)
+
+// columns and indexes added to tables created by earlier versions, on Open.
+// Each column starts with its name, DATETIME is given the precision of MySQLConfig.MySQL6
+var (
+	SQL_EVENT_ADDED_COLUMNS = []string{
+		`group_key VARCHAR(128) NOT NULL DEFAULT ''`,
+		`parent_id VARCHAR(128) NOT NULL DEFAULT ''`,
+		`deadline DATETIME DEFAULT NULL`,
+		`cron_spec VARCHAR(128) NOT NULL DEFAULT ''`,
+		`repeat_interval_msec BIGINT NOT NULL DEFAULT 0`,
+		`max_occurrences INT NOT NULL DEFAULT 0`,
+		`end_time DATETIME DEFAULT NULL`,
+		`occurrence INT NOT NULL DEFAULT 1`,
+		`follow_ups TEXT`,
+		`first_attempt DATETIME DEFAULT NULL`,
+		`backoff_policy VARCHAR(64) NOT NULL DEFAULT ''`,
+		`occurrence_time DATETIME DEFAULT NULL`,
+		`partition_id INT UNSIGNED NOT NULL DEFAULT 0`,
+	}
+	SQL_EVENT_ADDED_INDEXES = []string{
+		`KEY idx_group_key(group_key)`,
+		`KEY idx_parent_id(parent_id)`,
+		`KEY idx_status_trigger_type_time(status, trigger_type, trigger_time)`,
+		`KEY idx_owner_trigger_time(owner, trigger_time)`,
+	}
+	// filling columns added to existing rows, by the instance adding them
+	SQL_TMPL_EVENT_FILLED_COLUMNS = map[string]string{
+		"partition_id": `UPDATE %s SET partition_id=CRC32(id)`,
+	}
+	SQL_DEAD_LETTER_ADDED_COLUMNS = []string{
+		`first_attempt DATETIME DEFAULT NULL`,
+		`backoff_policy VARCHAR(64) NOT NULL DEFAULT ''`,
+		`occurrence_time DATETIME DEFAULT NULL`,
+	}
+)
+
+// MySQL errors of columns and indexes added in the meantime, e.g. by instances starting at once
+const (
+	mysqlErrDupFieldName = 1060
+	mysqlErrDupKeyName   = 1061
+)
+
+const (
+	SQL_SELECT_TABLE_COLUMNS = `SELECT COLUMN_NAME FROM information_schema.COLUMNS
+ WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=?`
+	SQL_SELECT_TABLE_INDEXES = `SELECT DISTINCT INDEX_NAME FROM information_schema.STATISTICS
+ WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=?`
+)
+
+// migrateTable adds columns and indexes ("KEY name(columns)") missing in table, added columns are filled
+// by the query of their name in fills
+func migrateTable(db *sql.DB, table string, suf string, columns []string, indexes []string, fills map[string]string) error {
+	existing, err := selectNames(db, SQL_SELECT_TABLE_COLUMNS, table)
+	if err != nil {
+		return err
+	}
+	for _, column := range columns {
+		name := strings.Fields(column)[0]
+		if existing[name] {
+			continue
+		}
+		column = strings.Replace(column, "DATETIME", "DATETIME"+suf, 1)
+		glog.Warningf("Add column to %s: %s", table, column)
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, column)); err != nil {
+			if isMySQLError(err, mysqlErrDupFieldName) {
+				// added and filled by another instance
+				continue
+			}
+			return err
+		}
+		if fill, ok := fills[name]; ok {
+			glog.Warningf("Fill column of %s: %s", table, name)
+			if _, err := db.Exec(fmt.Sprintf(fill, table)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if existing, err = selectNames(db, SQL_SELECT_TABLE_INDEXES, table); err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		name := strings.SplitN(strings.Fields(index)[1], "(", 2)[0]
+		if existing[name] {
+			continue
+		}
+		glog.Warningf("Add index to %s: %s", table, index)
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD %s", table, index)); err != nil && !isMySQLError(err, mysqlErrDupKeyName) {
+			return err
+		}
+	}
+	return nil
+}
+
+func isMySQLError(err error, number uint16) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == number
+}
+
+func selectNames(db *sql.DB, query string, table string) (map[string]bool, error) {
+	rows, err := db.Query(query, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names[name] = true
+	}
+	return names, rows.Err()
+}
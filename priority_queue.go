@@ -142,6 +142,23 @@ func (self *PQ) Lookup(key string) int {
 	}
 }
 
+// Get returns the item of key, nil if it is not in the queue
+func (self *PQ) Get(key string) PQItem {
+	if item, ok := self.lookupMap[key]; ok {
+		return item.Value.(PQItem)
+	}
+	return nil
+}
+
+// Items returns all items in no particular order
+func (self *PQ) Items() []PQItem {
+	items := make([]PQItem, len(self.heap.items))
+	for i, item := range self.heap.items {
+		items[i] = item.Value.(PQItem)
+	}
+	return items
+}
+
 func (self *PQ) Len() int {
 	return self.heap.Len()
 }
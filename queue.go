@@ -1,9 +1,11 @@
 package futurama
 
 import (
+	"context"
 	"fmt"
 	"github.com/facebookgo/inject"
 	"github.com/golang/glog"
+	"sync"
 	"time"
 )
 
@@ -17,7 +19,13 @@ type Queue struct {
 
 	scheduler *Scheduler
 	stat      *Stat
-	quitChan  chan chan bool
+	quitChan  chan *quitRequest
+
+	shutdownTimeout time.Duration
+
+	jobMutex sync.Mutex
+	jobs     []*Event
+	started  bool
 
 	nbTriggered Seq32
 	nbGiveup    Seq32
@@ -31,10 +39,17 @@ func CreateCustomQueue(cfg *Config, triggers map[string]TriggerInterface) *Queue
 	return &Queue{
 		scheduler: scheduler,
 		stat:      stat,
-		quitChan:  make(chan chan bool),
+		quitChan:  make(chan *quitRequest),
+
+		shutdownTimeout: time.Duration(cfg.ShutdownTimeoutMSec) * time.Millisecond,
 	}
 }
 
+type quitRequest struct {
+	ctx  context.Context
+	done chan error
+}
+
 func CreateQueue(cfg *Config, triggers map[string]TriggerInterface) (*Queue, error) {
 	q := CreateCustomQueue(cfg, triggers)
 	store := NewMySQLStore(cfg)
@@ -66,6 +81,9 @@ func (self *Queue) Populate(store StoreInterface, consumer ConsumerInterface) (*
 	if s, ok := consumer.(StatInterface); ok {
 		self.stat.Add(s)
 	}
+	if c, ok := consumer.(CapacityConsumerInterface); ok {
+		c.SetCapacity(self.scheduler.capacity)
+	}
 	return self, nil
 }
 
@@ -77,7 +95,18 @@ func (self *Queue) Start() error {
 		return err
 	}
 
+	self.jobMutex.Lock()
+	self.started = true
+	jobs := self.jobs
+	self.jobMutex.Unlock()
+	for _, ev := range jobs {
+		if err := self.saveJob(ev); err != nil {
+			return err
+		}
+	}
+
 	self.Consumer.Start()
+	self.scheduler.start()
 	self.stat.Start()
 
 	go func() {
@@ -87,16 +116,16 @@ func (self *Queue) Start() error {
 
 		for {
 			select {
-			case c := <-self.quitChan:
+			case req := <-self.quitChan:
 				self.stat.Stop()
 				self.Consumer.Stop()
-				self.scheduler.clear()
+				err := self.scheduler.shutdown(req.ctx)
 				self.Store.Close()
-				close(c)
+				req.done <- err
 				return
 			case eventList := <-self.Consumer.Events():
 				for _, ev := range eventList {
-					if ev.Status == EventStatus_DEFAULT {
+					if ev.Status == EventStatus_DEFAULT || ev.Status == EventStatus_PENDING {
 						self.scheduler.add(ev)
 					} else {
 						self.scheduler.cancel(ev)
@@ -110,20 +139,268 @@ func (self *Queue) Start() error {
 	return nil
 }
 
+// Stop shuts down the queue, running triggers are interrupted after SchedulerConfig.ShutdownTimeoutMSec
 func (self *Queue) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), self.shutdownTimeout)
+	defer cancel()
+	self.Shutdown(ctx)
+}
+
+// Shutdown stops claiming events and waits for running triggers until ctx is done, then interrupts them.
+// Events not completed are released to be triggered by other instances at once,
+// returns ctx.Err() if triggers have been interrupted
+func (self *Queue) Shutdown(ctx context.Context) error {
 	glog.Infoln("Stop queue")
-	c := make(chan bool)
-	self.quitChan <- c
-	<-c
+	req := &quitRequest{ctx, make(chan error, 1)}
+	self.quitChan <- req
+	return <-req.done
 }
 
 func (self *Queue) Create(triggerType string, triggerTime time.Time, data interface{}) string {
 	ev := NewEvent(triggerType, triggerTime, data)
+	return self.CreateEvent(ev)
+}
+
+// CreateWithDeadline creates an event which is completed as EXPIRED instead of being triggered after deadline
+func (self *Queue) CreateWithDeadline(triggerType string, triggerTime time.Time, deadline time.Time, data interface{}) string {
+	ev := NewEvent(triggerType, triggerTime, data)
+	ev.Deadline = deadline
+	return self.CreateEvent(ev)
+}
+
+// CreateEvent saves an event built by NewEvent, use it to set optional fields e.g. GroupKey.
+// Once started, events due within the time window of the consumer are claimed and scheduled at once
+func (self *Queue) CreateEvent(ev *Event) string {
+	if consumer, ok := self.Consumer.(ClaimConsumerInterface); ok && self.scheduler.isOpen() {
+		claimed, err := consumer.Claim(ev)
+		if err != nil {
+			return ""
+		}
+		if claimed != nil {
+			self.scheduler.add(claimed)
+			return claimed.Id
+		}
+	}
 	return self.Store.Save(ev)
 }
 
+// CreateRecurring creates an event triggered on every occurrence of cronSpec until it is cancelled
+func (self *Queue) CreateRecurring(triggerType string, cronSpec string, data interface{}) (string, error) {
+	schedule, err := parseCron(cronSpec)
+	if err != nil {
+		return "", err
+	}
+	ev := NewEvent(triggerType, schedule.Next(time.Now()), data)
+	ev.CronSpec = cronSpec
+	if evId := self.CreateEvent(ev); evId != "" {
+		return evId, nil
+	}
+	return "", fmt.Errorf("Can not save event")
+}
+
+// CreateRepeating creates an event triggered every interval from triggerTime,
+// up to maxOccurrences times (if > 0) and until endTime (if not zero)
+func (self *Queue) CreateRepeating(triggerType string, triggerTime time.Time, interval time.Duration,
+	maxOccurrences int, endTime time.Time, data interface{}) (string, error) {
+	if interval <= 0 {
+		return "", fmt.Errorf("Invalid interval: %s", interval)
+	}
+	ev := NewEvent(triggerType, triggerTime, data)
+	ev.Interval = interval
+	ev.MaxOccurrences = maxOccurrences
+	ev.EndTime = endTime
+	if evId := self.CreateEvent(ev); evId != "" {
+		return evId, nil
+	}
+	return "", fmt.Errorf("Can not save event")
+}
+
+// JobId returns the id of the event of a job registered by RegisterJob
+func JobId(name string) string {
+	// "0" keeps it lower than ids of events due now, see MySQLStore.getEvents
+	return "0_job_" + name
+}
+
+// RegisterJob declares a cluster-wide job triggered on every occurrence of cronSpec.
+// Every instance can register the same job, it is kept as a single recurring event identified by
+// JobId(name) so that each occurrence is triggered once. Jobs registered before Start are saved on Start.
+func (self *Queue) RegisterJob(name string, triggerType string, cronSpec string, data interface{}) error {
+	if name == "" {
+		return fmt.Errorf("Empty job name")
+	}
+	schedule, err := parseCron(cronSpec)
+	if err != nil {
+		return err
+	}
+	ev := NewEvent(triggerType, schedule.Next(time.Now()), data)
+	ev.Id = JobId(name)
+	ev.CronSpec = cronSpec
+
+	self.jobMutex.Lock()
+	self.jobs = append(self.jobs, ev)
+	started := self.started
+	self.jobMutex.Unlock()
+
+	if started {
+		return self.saveJob(ev)
+	}
+	return nil
+}
+
+func (self *Queue) saveJob(ev *Event) error {
+	store, ok := self.Store.(JobStoreInterface)
+	if !ok {
+		return fmt.Errorf("Store does not support SaveJob")
+	}
+	return store.SaveJob(ev)
+}
+
+// Use wraps triggers of all trigger types with middlewares, the first one being the outermost.
+// Middlewares are appended to those of previous calls
+func (self *Queue) Use(middlewares ...TriggerMiddleware) {
+	self.scheduler.use(middlewares...)
+}
+
+// SetBackoffPolicy delays retries of events of triggerType by policy instead of the retry_* settings of the trigger type
+func (self *Queue) SetBackoffPolicy(triggerType string, policy BackoffPolicy) {
+	self.scheduler.backoffMutex.Lock()
+	defer self.scheduler.backoffMutex.Unlock()
+	if policy == nil {
+		delete(self.scheduler.backoffPolicies, triggerType)
+	} else {
+		self.scheduler.backoffPolicies[triggerType] = policy
+	}
+}
+
+// RegisterBackoffPolicy names a policy for events whose BackoffPolicy is name,
+// every instance consuming such events should register it
+func (self *Queue) RegisterBackoffPolicy(name string, policy BackoffPolicy) error {
+	if name == "" || policy == nil {
+		return fmt.Errorf("Empty backoff policy name or policy")
+	}
+	self.scheduler.backoffMutex.Lock()
+	defer self.scheduler.backoffMutex.Unlock()
+	self.scheduler.namedBackoffPolicies[name] = policy
+	return nil
+}
+
+// Ack completes an event triggered with EventStatus_PENDING, from any instance. RETRY retries the event
+// according to its retry policy, other statuses complete it as if returned by its trigger
+func (self *Queue) Ack(evId string, status EventStatus, data interface{}) error {
+	if status == EventStatus_DEFAULT || status == EventStatus_PENDING {
+		return fmt.Errorf("Invalid ack status: %s", status)
+	}
+	store, ok := self.Store.(AckStoreInterface)
+	if !ok {
+		return fmt.Errorf("Store does not support Ack")
+	}
+	ev, err := store.Ack(evId)
+	if err != nil {
+		return err
+	}
+	if ev == nil {
+		return fmt.Errorf("Event is not pending: %s", evId)
+	}
+	self.scheduler.ack(ev, &TriggerResult{Status: status, Data: data})
+	return nil
+}
+
+// Cancel cancels a pending event, interrupting its trigger if running on this instance.
+// Events descending from it through ParentId are cancelled along with it if the store keeps them,
+// see CancelCascade
 func (self *Queue) Cancel(evId string) error {
-	return self.Store.Cancel(evId)
+	if _, ok := self.Store.(TreeStoreInterface); ok {
+		_, err := self.CancelCascade(evId)
+		return err
+	}
+	err := self.Store.Cancel(evId)
+	self.scheduler.interrupt(evId)
+	return err
+}
+
+// CancelCascade cancels an event and all events descending from it through ParentId in one transaction,
+// descendants are cancelled even if the event itself has been completed. Returns the number of cancelled events
+func (self *Queue) CancelCascade(evId string) (int, error) {
+	store, ok := self.Store.(TreeStoreInterface)
+	if !ok {
+		return 0, fmt.Errorf("Store does not support CancelCascade")
+	}
+	cancelled, err := store.CancelCascade(evId)
+	self.scheduler.interrupt(evId)
+	for _, id := range cancelled {
+		if id != evId {
+			self.scheduler.interrupt(id)
+		}
+	}
+	return len(cancelled), err
+}
+
+// Get returns an event and ids of its children, nil if the event does not exist (anymore). ev.Status tells
+// whether it is still pending, or e.g. cancelled but not deleted yet
+func (self *Queue) Get(evId string) (*Event, error) {
+	store, ok := self.Store.(TreeStoreInterface)
+	if !ok {
+		return nil, fmt.Errorf("Store does not support Get")
+	}
+	return store.Get(evId)
+}
+
+// CancelWhere cancels pending events matching filter, interrupting their triggers running on this instance
+func (self *Queue) CancelWhere(filter *EventFilter) (int, error) {
+	store, ok := self.Store.(FilterStoreInterface)
+	if !ok {
+		return 0, fmt.Errorf("Store does not support CancelWhere")
+	}
+	n, err := store.CancelWhere(filter)
+	if n > 0 {
+		self.scheduler.interruptCancelled()
+	}
+	return n, err
+}
+
+func (self *Queue) CancelGroup(groupKey string) (int, error) {
+	return self.CancelWhere(&EventFilter{GroupKey: groupKey})
+}
+
+func (self *Queue) ListGroup(groupKey string) ([]*Event, error) {
+	store, ok := self.Store.(FilterStoreInterface)
+	if !ok {
+		return nil, fmt.Errorf("Store does not support ListWhere")
+	}
+	return store.ListWhere(&EventFilter{GroupKey: groupKey}, 0)
+}
+
+func (self *Queue) CountGroup(groupKey string) (int, error) {
+	store, ok := self.Store.(FilterStoreInterface)
+	if !ok {
+		return 0, fmt.Errorf("Store does not support CountWhere")
+	}
+	return store.CountWhere(&EventFilter{GroupKey: groupKey})
+}
+
+func (self *Queue) ListDeadLetters(filter *EventFilter, limit int) ([]*DeadLetter, error) {
+	store, ok := self.Store.(DeadLetterStoreInterface)
+	if !ok {
+		return nil, fmt.Errorf("Store does not support dead letters")
+	}
+	return store.ListDeadLetters(filter, limit)
+}
+
+// Redrive moves a dead letter back to the queue, to be triggered at triggerTime
+func (self *Queue) Redrive(evId string, triggerTime time.Time) error {
+	store, ok := self.Store.(DeadLetterStoreInterface)
+	if !ok {
+		return fmt.Errorf("Store does not support dead letters")
+	}
+	return store.Redrive(evId, triggerTime)
+}
+
+func (self *Queue) RedriveWhere(filter *EventFilter, triggerTime time.Time) (int, error) {
+	store, ok := self.Store.(DeadLetterStoreInterface)
+	if !ok {
+		return 0, fmt.Errorf("Store does not support dead letters")
+	}
+	return store.RedriveWhere(filter, triggerTime)
 }
 
 func (self *Queue) GetStat() map[string]interface{} {
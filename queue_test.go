@@ -88,3 +88,94 @@ func TestQueue_Multiple(t *testing.T) {
 	time.Sleep(time.Second)
 	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 0)
 }
+
+func TestQueue_Group(t *testing.T) {
+	cfg := DefaultConfig()
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	q, testChan := SetupQueue(cfg)
+	defer q.Stop()
+	assert := assert.New(t)
+
+	triggerTime := time.Now().Add(2 * time.Second)
+	for i := 0; i < 3; i++ {
+		ev := NewEvent(Test_TriggerType_Default, triggerTime.Add(time.Duration(i)*time.Second), nil)
+		ev.GroupKey = "player:1"
+		assert.NotEmpty(q.CreateEvent(ev))
+	}
+	ev := NewEvent(Test_TriggerType_Default, triggerTime, nil)
+	ev.GroupKey = "player:2"
+	evId := q.CreateEvent(ev)
+
+	count, err := q.CountGroup("player:1")
+	assert.NoError(err)
+	assert.Equal(3, count)
+
+	evList, err := q.ListGroup("player:1")
+	assert.NoError(err)
+	assert.Len(evList, 3)
+	for _, ev := range evList {
+		assert.Equal("player:1", ev.GroupKey)
+	}
+
+	n, err := q.CancelGroup("player:1")
+	assert.NoError(err)
+	assert.Equal(3, n)
+	count, _ = q.CountGroup("player:1")
+	assert.Equal(0, count)
+
+	select {
+	case id := <-testChan:
+		assert.Equal(id, evId)
+	case <-time.After(3 * time.Second):
+		assert.Fail("event is not triggered")
+	}
+	select {
+	case <-testChan:
+		assert.Fail("cancelled group should not be triggered")
+	case <-time.After(3 * time.Second):
+	}
+	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 0)
+}
+
+func TestQueue_RegisterJob(t *testing.T) {
+	cfg := DefaultConfig()
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	assert := assert.New(t)
+
+	var nbTriggered Seq32
+	quitChan := make(chan bool)
+	defer close(quitChan)
+	for i := 0; i < 2; i++ {
+		cfg := DefaultConfig()
+		cfg.ConsumerName = fmt.Sprintf("q%d", i)
+		c := make(chan string, 64)
+		q, _ := CreateQueue(cfg, map[string]TriggerInterface{
+			Test_TriggerType_Default: &TestTrigger_Schedule{c},
+		})
+		assert.Error(q.RegisterJob("", Test_TriggerType_Default, "* * * * * *", nil))
+		assert.Error(q.RegisterJob("job", Test_TriggerType_Default, "* * *", nil))
+		assert.NoError(q.RegisterJob("every-2s", Test_TriggerType_Default, "*/2 * * * * *", nil))
+		q.Start()
+		defer q.Stop()
+
+		go func() {
+			for {
+				select {
+				case id := <-c:
+					assert.Equal(JobId("every-2s"), id)
+					nbTriggered.Next()
+				case <-quitChan:
+					return
+				}
+			}
+		}()
+	}
+
+	evList := TestOnly_SelectEvents(&cfg.MySQLConfig)
+	assert.Len(evList, 1)
+	assert.Equal(JobId("every-2s"), evList[0].Id)
+
+	time.Sleep(6100 * time.Millisecond)
+	assert.EqualValues(3, nbTriggered.Get())
+	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 1)
+}
@@ -0,0 +1,47 @@
+package futurama
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket allows rate events per second on average, and up to burst events at once
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token, returns how long to wait until it is available. Tokens are reserved in advance
+// so that events waiting for their token are allowed in the order of their reservations
+func (self *tokenBucket) reserve(now time.Time) time.Duration {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if now.After(self.last) {
+		self.tokens += now.Sub(self.last).Seconds() * self.rate
+		if self.tokens > self.burst {
+			self.tokens = self.burst
+		}
+		self.last = now
+	}
+	self.tokens--
+	if self.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-self.tokens / self.rate * float64(time.Second))
+}
@@ -0,0 +1,31 @@
+package futurama
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRateLimit_TokenBucket(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	bucket := newTokenBucket(10, 3)
+	bucket.last = now
+
+	// burst
+	assert.Equal(time.Duration(0), bucket.reserve(now))
+	assert.Equal(time.Duration(0), bucket.reserve(now))
+	assert.Equal(time.Duration(0), bucket.reserve(now))
+	// reserved one after another
+	assert.InDelta(100*time.Millisecond, bucket.reserve(now), float64(time.Millisecond))
+	assert.InDelta(200*time.Millisecond, bucket.reserve(now), float64(time.Millisecond))
+
+	// refilled up to burst
+	now = now.Add(time.Second)
+	for i := 0; i < 3; i++ {
+		assert.Equal(time.Duration(0), bucket.reserve(now))
+	}
+	assert.InDelta(100*time.Millisecond, bucket.reserve(now), float64(time.Millisecond))
+
+	assert.Equal(1.0, newTokenBucket(10, 0).burst)
+}
@@ -0,0 +1,92 @@
+package futurama
+
+import (
+	"fmt"
+	"github.com/robfig/cron"
+	"strings"
+	"time"
+)
+
+// what to do with occurrences of a recurring event missed while no scheduler was running
+const (
+	MissedPolicy_ONCE = "once" // trigger the late occurrence once, then continue from now
+	MissedPolicy_ALL  = "all"  // trigger every missed occurrence
+	MissedPolicy_SKIP = "skip" // do not trigger late occurrences, continue from now
+)
+
+type cronSchedule struct {
+	schedule cron.Schedule
+	location *time.Location
+}
+
+// parseCron accepts standard 5 fields, 6 fields (with seconds) or descriptor (e.g. "@daily") specs,
+// optionally prefixed by a time zone e.g. "CRON_TZ=Asia/Tokyo 0 0 * * *"
+func parseCron(spec string) (*cronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	location := time.Local
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		i := strings.Index(spec, " ")
+		if i < 0 {
+			return nil, fmt.Errorf("Missing cron spec after time zone: %s", spec)
+		}
+		tz := spec[strings.Index(spec, "=")+1 : i]
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid time zone %s: %s", tz, err)
+		}
+		location = loc
+		spec = strings.TrimSpace(spec[i:])
+	}
+
+	var schedule cron.Schedule
+	var err error
+	if len(strings.Fields(spec)) == 6 {
+		schedule, err = cron.Parse(spec)
+	} else {
+		schedule, err = cron.ParseStandard(spec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cronSchedule{schedule, location}, nil
+}
+
+func (self *cronSchedule) Next(t time.Time) time.Time {
+	return self.schedule.Next(t.In(self.location))
+}
+
+// nextOccurrence returns trigger time of the next occurrence of a recurring event, following the scheduled time
+// of the current one whatever its retries, zero time if the event is not recurring or the series has ended
+func nextOccurrence(ev *Event, missedPolicy string, now time.Time) (time.Time, error) {
+	if !ev.IsRecurring() {
+		return time.Time{}, nil
+	}
+	if ev.MaxOccurrences > 0 && ev.Occurrence >= ev.MaxOccurrences {
+		return time.Time{}, nil
+	}
+
+	var next time.Time
+	if ev.CronSpec != "" {
+		schedule, err := parseCron(ev.CronSpec)
+		if err != nil {
+			return time.Time{}, err
+		}
+		after := ev.occurrenceTime()
+		if missedPolicy != MissedPolicy_ALL && now.After(after) {
+			after = now
+		}
+		next = schedule.Next(after)
+	} else {
+		next = ev.occurrenceTime().Add(ev.Interval)
+		if missedPolicy != MissedPolicy_ALL && !next.After(now) {
+			// keep the interval grid, skip the ticks already passed
+			missed := now.Sub(next)/ev.Interval + 1
+			next = next.Add(missed * ev.Interval)
+		}
+	}
+
+	if !ev.EndTime.IsZero() && next.After(ev.EndTime) {
+		return time.Time{}, nil
+	}
+	return next, nil
+}
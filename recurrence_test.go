@@ -0,0 +1,107 @@
+package futurama
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRecurrence_ParseCron(t *testing.T) {
+	assert := assert.New(t)
+
+	base := time.Date(2015, 6, 1, 10, 30, 15, 0, time.UTC)
+
+	s, err := parseCron("CRON_TZ=UTC 0 12 * * *")
+	assert.NoError(err)
+	assert.Equal(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC), s.Next(base).UTC())
+
+	s, err = parseCron("TZ=Asia/Tokyo 0 0 * * *")
+	assert.NoError(err)
+	assert.Equal(time.Date(2015, 6, 1, 15, 0, 0, 0, time.UTC), s.Next(base).UTC())
+
+	s, err = parseCron("CRON_TZ=UTC */10 * * * * *")
+	assert.NoError(err)
+	assert.Equal(time.Date(2015, 6, 1, 10, 30, 20, 0, time.UTC), s.Next(base).UTC())
+
+	s, err = parseCron("CRON_TZ=UTC @hourly")
+	assert.NoError(err)
+	assert.Equal(time.Date(2015, 6, 1, 11, 0, 0, 0, time.UTC), s.Next(base).UTC())
+
+	for _, spec := range []string{"", "* * *", "61 * * * *", "CRON_TZ=Nowhere/Unknown * * * * *", "CRON_TZ=UTC"} {
+		_, err = parseCron(spec)
+		assert.Error(err, spec)
+	}
+}
+
+func TestRecurrence_NextOccurrence(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2015, 6, 1, 10, 30, 15, 0, time.UTC)
+	ev := NewEvent(Test_TriggerType_Default, now.Add(-2*time.Hour), nil)
+
+	next, err := nextOccurrence(ev, MissedPolicy_ONCE, now)
+	assert.NoError(err)
+	assert.True(next.IsZero())
+
+	ev.CronSpec = "CRON_TZ=UTC 0 * * * *"
+	for _, policy := range []string{MissedPolicy_ONCE, MissedPolicy_SKIP} {
+		next, err = nextOccurrence(ev, policy, now)
+		assert.NoError(err)
+		assert.Equal(time.Date(2015, 6, 1, 11, 0, 0, 0, time.UTC), next.UTC())
+	}
+
+	next, err = nextOccurrence(ev, MissedPolicy_ALL, now)
+	assert.NoError(err)
+	assert.Equal(time.Date(2015, 6, 1, 9, 0, 0, 0, time.UTC), next.UTC())
+
+	// retried past the following slots, which are not skipped
+	ev.reschedule(now.Add(-30 * time.Minute))
+	next, err = nextOccurrence(ev, MissedPolicy_ALL, now)
+	assert.NoError(err)
+	assert.Equal(time.Date(2015, 6, 1, 9, 0, 0, 0, time.UTC), next.UTC())
+}
+
+func TestRecurrence_NextOccurrence_Interval(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2015, 6, 1, 10, 30, 15, 0, time.UTC)
+	ev := NewEvent(Test_TriggerType_Default, now, nil)
+	ev.Interval = 10 * time.Minute
+
+	next, err := nextOccurrence(ev, MissedPolicy_ONCE, now)
+	assert.NoError(err)
+	assert.Equal(now.Add(10*time.Minute), next)
+
+	// missed ticks
+	late := now.Add(35 * time.Minute)
+	next, _ = nextOccurrence(ev, MissedPolicy_ONCE, late)
+	assert.Equal(now.Add(40*time.Minute), next)
+	next, _ = nextOccurrence(ev, MissedPolicy_ALL, late)
+	assert.Equal(now.Add(10*time.Minute), next)
+
+	// retries do not shift the interval grid
+	retried := *ev
+	retried.reschedule(now.Add(3 * time.Minute))
+	retried.reschedule(now.Add(7 * time.Minute))
+	assert.Equal(now, retried.OccurrenceTime)
+	next, _ = nextOccurrence(&retried, MissedPolicy_ONCE, now.Add(7*time.Minute))
+	assert.Equal(now.Add(10*time.Minute), next)
+
+	// occurrence limit
+	ev.MaxOccurrences = 3
+	ev.Occurrence = 2
+	next, _ = nextOccurrence(ev, MissedPolicy_ONCE, now)
+	assert.False(next.IsZero())
+	ev.Occurrence = 3
+	next, _ = nextOccurrence(ev, MissedPolicy_ONCE, now)
+	assert.True(next.IsZero())
+
+	// end time
+	ev.MaxOccurrences = 0
+	ev.EndTime = now.Add(10 * time.Minute)
+	next, _ = nextOccurrence(ev, MissedPolicy_ONCE, now)
+	assert.Equal(now.Add(10*time.Minute), next)
+	ev.EndTime = now.Add(9 * time.Minute)
+	next, _ = nextOccurrence(ev, MissedPolicy_ONCE, now)
+	assert.True(next.IsZero())
+}
@@ -0,0 +1,120 @@
+package futurama
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const Test_TriggerType_Pending = "test-pending"
+
+func TestScheduler_Ack(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TriggerTypes = map[string]*TriggerTypeConfig{
+		Test_TriggerType_Pending: {AckTimeoutMSec: 1000, RetryIntervalMSec: 100, RetryJitter: RetryJitter_NONE},
+	}
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	c := make(chan string, 64)
+	q, _ := CreateQueue(cfg, map[string]TriggerInterface{
+		// pending on the first attempt
+		Test_TriggerType_Pending: ContextTriggerFunc(func(ctx context.Context, ev *Event) *TriggerResult {
+			defer func() {
+				c <- ev.Id
+			}()
+			if ev.Attempts == 0 {
+				return &TriggerResult{Status: EventStatus_PENDING}
+			}
+			return &TriggerResult{Status: EventStatus_OK}
+		}),
+	})
+	q.Start()
+	defer q.Stop()
+	assert := assert.New(t)
+
+	triggerTime := time.Now().Add(time.Second)
+	ackedId := q.Create(Test_TriggerType_Pending, triggerTime, "")
+	timeoutId := q.Create(Test_TriggerType_Pending, triggerTime, "")
+	for i := 0; i < 2; i++ {
+		select {
+		case <-c:
+		case <-time.After(2 * time.Second):
+			assert.FailNow("event is not triggered")
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	evList := TestOnly_SelectEvents(&cfg.MySQLConfig)
+	assert.Len(evList, 2)
+	for _, ev := range evList {
+		assert.EqualValues(EventStatus_PENDING, ev.Status)
+	}
+	assert.Error(q.Ack(ackedId, EventStatus_PENDING, nil))
+	assert.NoError(q.Ack(ackedId, EventStatus_OK, nil))
+	assert.Error(q.Ack(ackedId, EventStatus_OK, nil))
+
+	// retried after the ack timeout
+	select {
+	case id := <-c:
+		assert.Equal(timeoutId, id)
+		assert.WithinDuration(triggerTime.Add(1100*time.Millisecond), time.Now(), 300*time.Millisecond)
+	case <-time.After(3 * time.Second):
+		assert.Fail("event is not retried")
+	}
+	time.Sleep(500 * time.Millisecond)
+	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 0)
+
+	stat := q.GetStat()
+	assert.EqualValues(2, stat["futurama.Scheduler.nbPending"])
+	assert.EqualValues(1, stat["futurama.Scheduler.nbAcked"])
+	assert.EqualValues(1, stat["futurama.Scheduler.nbAckTimeout"])
+	assert.EqualValues(2, stat["futurama.MySQLStore.nbComplete"])
+}
+
+func TestScheduler_Ack_Retry(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TriggerTypes = map[string]*TriggerTypeConfig{
+		Test_TriggerType_Pending: {AckTimeoutMSec: 4000, RetryIntervalMSec: 100, RetryJitter: RetryJitter_NONE},
+	}
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	c := make(chan string, 64)
+	q, _ := CreateQueue(cfg, map[string]TriggerInterface{
+		Test_TriggerType_Pending: ContextTriggerFunc(func(ctx context.Context, ev *Event) *TriggerResult {
+			defer func() {
+				c <- ev.Id
+			}()
+			if ev.Attempts == 0 {
+				return &TriggerResult{Status: EventStatus_PENDING}
+			}
+			return &TriggerResult{Status: EventStatus_OK}
+		}),
+	})
+	q.Start()
+	defer q.Stop()
+	assert := assert.New(t)
+
+	evId := q.Create(Test_TriggerType_Pending, time.Now().Add(time.Second), "")
+	select {
+	case <-c:
+	case <-time.After(2 * time.Second):
+		assert.FailNow("event is not triggered")
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	// the pending event still scheduled until its ack deadline is replaced by the retry
+	acked := time.Now()
+	assert.NoError(q.Ack(evId, EventStatus_RETRY, nil))
+	select {
+	case id := <-c:
+		assert.Equal(evId, id)
+		assert.WithinDuration(acked.Add(100*time.Millisecond), time.Now(), 500*time.Millisecond)
+	case <-time.After(3 * time.Second):
+		assert.Fail("event is not retried")
+	}
+	time.Sleep(200 * time.Millisecond)
+	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 0)
+
+	stat := q.GetStat()
+	assert.EqualValues(0, stat["futurama.Scheduler.nbAckTimeout"])
+	assert.EqualValues(0, stat["futurama.Scheduler.nbEvents"])
+}
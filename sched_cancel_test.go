@@ -87,3 +87,29 @@ func TestScheduler_Cancel_Unknown(t *testing.T) {
 	assert.EqualValues(stat["futurama.MySQLStore.nbCancel"], 1)
 	assert.EqualValues(stat["futurama.Scheduler.nbTriggered"], 0)
 }
+
+func TestScheduler_Cancel_Cascade(t *testing.T) {
+	cfg := DefaultConfig()
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	q, _ := SetupQueue(cfg)
+	defer q.Stop()
+	assert := assert.New(t)
+
+	triggerTime := time.Now().Add(time.Hour)
+	parentId := q.Create(Test_TriggerType_Default, triggerTime, "")
+	child := NewEvent(Test_TriggerType_Default, triggerTime, "")
+	child.ParentId = parentId
+	childId := q.CreateEvent(child)
+	otherId := q.Create(Test_TriggerType_Default, triggerTime, "")
+
+	// cancelling the parent cancels its children
+	assert.NoError(q.Cancel(parentId))
+	for _, ev := range TestOnly_SelectEvents(&cfg.MySQLConfig) {
+		switch ev.Id {
+		case parentId, childId:
+			assert.Equal(EventStatus_CANCEL, int(ev.Status))
+		case otherId:
+			assert.Equal(EventStatus_DEFAULT, int(ev.Status))
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package futurama
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestScheduler_Chain_FollowUps(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxRetry = 0
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	q, testChan := SetupQueue(cfg)
+	defer q.Stop()
+	assert := assert.New(t)
+
+	// march -> battle (-> return on success)
+	triggerTime := time.Now().Add(time.Second)
+	ev := NewEvent(Test_TriggerType_Default, triggerTime, nil)
+	ev.OnSuccess = &FollowUp{
+		TriggerType: Test_TriggerType_Retry,
+		Delay:       time.Second,
+		Data:        &RetryData{1, 0},
+		OnSuccess:   &FollowUp{TriggerType: Test_TriggerType_Default},
+		OnFailure:   &FollowUp{TriggerType: Test_TriggerType_Default, Delay: time.Second},
+	}
+	ev.OnFailure = &FollowUp{TriggerType: Test_TriggerType_Default}
+	evId := q.CreateEvent(ev)
+
+	ids := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		select {
+		case id := <-testChan:
+			ids = append(ids, id)
+		case <-time.After(4 * time.Second):
+			assert.Fail("follow-up is not triggered")
+		}
+	}
+	assert.Equal(evId, ids[0])
+	assert.NotEqual(evId, ids[1])
+	assert.NotEqual(ids[1], ids[2])
+	// OnFailure of the retried follow-up is triggered 1s after give up
+	assert.WithinDuration(time.Now(), triggerTime.Add(3*time.Second), 250*time.Millisecond)
+
+	time.Sleep(500 * time.Millisecond)
+	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 0)
+
+	stat := q.GetStat()
+	assert.EqualValues(stat["futurama.MySQLStore.nbSave"], 3)
+	assert.EqualValues(stat["futurama.MySQLStore.nbComplete"], 2)
+	assert.EqualValues(stat["futurama.MySQLStore.nbDeadLetter"], 1)
+}
+
+func TestScheduler_Chain_Trigger(t *testing.T) {
+	cfg := DefaultConfig()
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	testChan := make(chan string, 64)
+	q, _ := CreateQueue(cfg, map[string]TriggerInterface{
+		Test_TriggerType_Default: &TestTrigger_Schedule{testChan},
+		"test-chain": ContextTriggerFunc(func(ctx context.Context, ev *Event) *TriggerResult {
+			// dropped along with the retried attempt
+			ev.Chain(NewEvent(Test_TriggerType_Default, time.Now(), nil))
+			if ev.Attempts == 0 {
+				return &TriggerResult{Status: EventStatus_RETRY, TriggerTime: time.Now()}
+			}
+			return &TriggerResult{Status: EventStatus_OK}
+		}),
+	})
+	q.Start()
+	defer q.Stop()
+	assert := assert.New(t)
+
+	q.Create("test-chain", time.Now(), nil)
+	select {
+	case <-testChan:
+	case <-time.After(3 * time.Second):
+		assert.Fail("chained event is not triggered")
+	}
+	select {
+	case <-testChan:
+		assert.Fail("chained event of the retried attempt should not be created")
+	case <-time.After(time.Second):
+	}
+	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 0)
+}
+
+func TestStore_UpdateStatusAndSave(t *testing.T) {
+	TestStore_Save(t)
+
+	assert := assert.New(t)
+	cfg := DefaultConfig()
+	store := NewMySQLStore(cfg)
+	store.Open()
+	defer store.Close()
+
+	followUp := NewEvent(Test_TriggerType_Default, time.Now(), nil)
+	followUp.OnSuccess = &FollowUp{TriggerType: Test_TriggerType_Retry, Data: map[string]interface{}{"a": 1}}
+	err := store.UpdateStatusAndSave(&Event{Id: evId}, EventStatus_OK, []*Event{followUp})
+	assert.NoError(err)
+	assert.NotEmpty(followUp.Id)
+
+	evList := TestOnly_SelectEvents(&cfg.MySQLConfig)
+	assert.Len(evList, 1)
+	assert.Equal(followUp.Id, evList[0].Id)
+
+	evList, err = store.ListWhere(&EventFilter{TriggerType: Test_TriggerType_Default}, 0)
+	assert.NoError(err)
+	assert.Len(evList, 1)
+	assert.Equal(Test_TriggerType_Retry, evList[0].OnSuccess.TriggerType)
+	assert.Nil(evList[0].OnFailure)
+
+	// nothing is saved when the transaction fails
+	dup := NewEvent(Test_TriggerType_Default, time.Now(), nil)
+	err = store.saveInTx([]*Event{dup}, func(tx *sql.Tx) error {
+		return fmt.Errorf("failed")
+	})
+	assert.Error(err)
+	assert.Empty(dup.Id)
+	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 1)
+}
@@ -0,0 +1,148 @@
+package futurama
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const Test_TriggerType_Context = "test-context"
+
+func TestScheduler_Context_TimeoutAndCancel(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TriggerTypes = map[string]*TriggerTypeConfig{
+		Test_TriggerType_Context: {MaxRetry: -1, TimeoutMSec: 100},
+	}
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	c := make(chan string, 64)
+	q, _ := CreateQueue(cfg, map[string]TriggerInterface{
+		// waits for the interruption, or for 200ms if the event data is true
+		Test_TriggerType_Context: ContextTriggerFunc(func(ctx context.Context, ev *Event) *TriggerResult {
+			c <- ev.Id
+			if ev.Data == true {
+				time.Sleep(200 * time.Millisecond)
+				return &TriggerResult{Status: EventStatus_OK}
+			}
+			<-ctx.Done()
+			return &TriggerResult{Status: EventStatus_ERROR, Data: ctx.Err().Error()}
+		}),
+	})
+	q.Start()
+	defer q.Stop()
+	assert := assert.New(t)
+
+	triggerTime := time.Now().Add(time.Second)
+	timeoutId := q.Create(Test_TriggerType_Context, triggerTime, "")
+	q.Create(Test_TriggerType_Context, triggerTime, true)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-c:
+		case <-time.After(2 * time.Second):
+			assert.Fail("event is not triggered")
+		}
+	}
+	time.Sleep(500 * time.Millisecond)
+	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 0)
+
+	deadLetters, _ := q.ListDeadLetters(nil, 0)
+	assert.Len(deadLetters, 1)
+	assert.Equal(timeoutId, deadLetters[0].Id)
+	assert.Equal(context.DeadlineExceeded.Error(), deadLetters[0].LastResult)
+
+	// cancelled while running, without timeout
+	q.scheduler.triggerTypes[Test_TriggerType_Context].TimeoutMSec = 0
+	cancelId := q.Create(Test_TriggerType_Context, time.Now().Add(time.Second), "")
+	select {
+	case <-c:
+	case <-time.After(2 * time.Second):
+		assert.Fail("event is not triggered")
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(q.Cancel(cancelId))
+	time.Sleep(500 * time.Millisecond)
+	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 0)
+
+	// cancelled in bulk while running
+	ev := NewEvent(Test_TriggerType_Context, time.Now().Add(time.Second), "")
+	ev.GroupKey = "player1"
+	q.CreateEvent(ev)
+	select {
+	case <-c:
+	case <-time.After(2 * time.Second):
+		assert.Fail("event is not triggered")
+	}
+	time.Sleep(100 * time.Millisecond)
+	n, err := q.CancelGroup("player1")
+	assert.NoError(err)
+	assert.Equal(1, n)
+	time.Sleep(500 * time.Millisecond)
+	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 0)
+
+	stat := q.GetStat()
+	assert.EqualValues(1, stat["futurama.Scheduler.nbTimeout"])
+	assert.EqualValues(2, stat["futurama.Scheduler.nbInterrupted"])
+	assert.EqualValues(0, stat["futurama.Scheduler.nbLostOwnership"])
+	assert.EqualValues(1, stat["futurama.Scheduler.nbGiveup"])
+}
+
+func TestScheduler_Context_Legacy(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TriggerTypeDefault.TimeoutMSec = 100
+	c := make(chan string, 1)
+	scheduler := newScheduler(cfg, map[string]TriggerInterface{
+		Test_TriggerType_Default: &TestTrigger_Schedule{c},
+		Test_TriggerType_Context: ContextTriggerFunc(func(ctx context.Context, ev *Event) *TriggerResult {
+			return nil
+		}),
+	})
+	assert := assert.New(t)
+
+	// called without a context to be done, registered as running but not interrupted
+	ev := NewEvent(Test_TriggerType_Default, time.Now(), "")
+	_, ctx, cancel := scheduler.triggerContext(ev, scheduler.getTriggerTypeConfig(ev.TriggerType))
+	assert.Nil(ctx.Done())
+	assert.Len(scheduler.inflight, 1)
+	scheduler.interrupt(ev.Id)
+	cancel()
+	assert.Len(scheduler.inflight, 0)
+
+	ev = NewEvent(Test_TriggerType_Context, time.Now(), "")
+	_, ctx, cancel = scheduler.triggerContext(ev, scheduler.getTriggerTypeConfig(ev.TriggerType))
+	assert.NotNil(ctx.Done())
+	scheduler.interrupt(ev.Id)
+	assert.Equal(context.Canceled, ctx.Err())
+	cancel()
+}
+
+func TestScheduler_Context_WithContext(t *testing.T) {
+	assert := assert.New(t)
+	c := make(chan string, 1)
+	trigger := WithContext(&TestTrigger_Schedule{c})
+	ev := NewEvent(Test_TriggerType_Default, time.Now(), "")
+	ev.Id = "1_test"
+
+	result := trigger.TriggerContext(context.Background(), ev)
+	assert.EqualValues(EventStatus_OK, result.Status)
+	assert.Equal("1_test", <-c)
+
+	// not interrupted, run until it returns
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result = trigger.TriggerContext(ctx, ev)
+	assert.EqualValues(EventStatus_OK, result.Status)
+	assert.Equal("1_test", <-c)
+
+	triggerFunc := ContextTriggerFunc(func(ctx context.Context, ev *Event) *TriggerResult {
+		return nil
+	})
+	_, adapted := WithContext(triggerFunc).(*contextTrigger)
+	assert.False(adapted)
+
+	// recovered by the scheduler
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	assert.Panics(func() {
+		WithContext(&TestTrigger_Panic{}).TriggerContext(ctx, ev)
+	})
+}
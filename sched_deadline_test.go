@@ -0,0 +1,69 @@
+package futurama
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestScheduler_Deadline_Expired(t *testing.T) {
+	cfg := DefaultConfig()
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	q, testChan := SetupQueue(cfg)
+	defer q.Stop()
+	assert := assert.New(t)
+
+	// stale event, as if it was created before an outage
+	now := time.Now()
+	q.CreateWithDeadline(Test_TriggerType_Default, now.Add(-5*time.Second), now.Add(-time.Second), "")
+	evId := q.CreateWithDeadline(Test_TriggerType_Default, now.Add(-5*time.Second), now.Add(time.Minute), "")
+
+	select {
+	case id := <-testChan:
+		assert.Equal(id, evId)
+	case <-time.After(time.Second):
+		assert.Fail("event is not triggered")
+	}
+	select {
+	case <-testChan:
+		assert.Fail("expired event is triggered")
+	case <-time.After(time.Second):
+	}
+	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 0)
+
+	stat := q.GetStat()
+	assert.EqualValues(stat["futurama.MySQLStore.nbComplete"], 2)
+	assert.EqualValues(stat["futurama.Scheduler.nbTriggered"], 1)
+	assert.EqualValues(stat["futurama.Scheduler.nbExpired"], 1)
+}
+
+func TestScheduler_Deadline_Retry(t *testing.T) {
+	cfg := DefaultConfig()
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	q, testChan := SetupQueue(cfg)
+	defer q.Stop()
+	assert := assert.New(t)
+
+	triggerTime := time.Now().Add(time.Second)
+	retryTime := triggerTime.Add(3 * time.Second)
+	evId := q.CreateWithDeadline(Test_TriggerType_Retry, triggerTime, triggerTime.Add(2*time.Second),
+		&RetryData{1, retryTime.UnixNano()})
+
+	select {
+	case id := <-testChan:
+		assert.Equal(id, evId)
+	case <-time.After(2 * time.Second):
+		assert.Fail("event is not triggered")
+	}
+	select {
+	case <-testChan:
+		assert.Fail("event is retried after deadline")
+	case <-time.After(4 * time.Second):
+	}
+	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 0)
+
+	stat := q.GetStat()
+	assert.EqualValues(stat["futurama.MySQLStore.nbComplete"], 1)
+	assert.EqualValues(stat["futurama.MySQLStore.nbRetry"], 0)
+	assert.EqualValues(stat["futurama.Scheduler.nbExpired"], 1)
+}
@@ -0,0 +1,101 @@
+package futurama
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestScheduler_Late_Policies(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TriggerTypes = map[string]*TriggerTypeConfig{
+		Test_TriggerType_Default: {LatePolicy: LatePolicy_SPREAD, LateSpreadMSec: 2000},
+		Test_TriggerType_Retry:   {LatePolicy: LatePolicy_SKIP, LateThresholdMSec: 10000},
+		Test_TriggerType_Panic:   {LatePolicy: LatePolicy_DEAD_LETTER},
+	}
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	q, testChan := SetupQueue(cfg)
+	defer q.Stop()
+	assert := assert.New(t)
+
+	before := time.Now()
+	spreadId := q.Create(Test_TriggerType_Default, before.Add(-5*time.Second), "")
+	// under the threshold of its trigger type
+	retryId := q.Create(Test_TriggerType_Retry, before.Add(-5*time.Second), &RetryData{0, 0})
+	q.Create(Test_TriggerType_Retry, before.Add(-15*time.Second), &RetryData{0, 0})
+	q.Create(Test_TriggerType_Panic, before.Add(-5*time.Second), "")
+
+	triggered := make(map[string]time.Time)
+	for i := 0; i < 2; i++ {
+		select {
+		case id := <-testChan:
+			triggered[id] = time.Now()
+		case <-time.After(3 * time.Second):
+			assert.Fail("late event is not triggered")
+		}
+	}
+	select {
+	case <-testChan:
+		assert.Fail("late event is triggered")
+	case <-time.After(time.Second):
+	}
+	assert.Contains(triggered, spreadId)
+	assert.Contains(triggered, retryId)
+	assert.WithinDuration(triggered[retryId], before, 500*time.Millisecond)
+	assert.WithinDuration(triggered[spreadId], before.Add(time.Second), 1200*time.Millisecond)
+	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 0)
+
+	stat := q.GetStat()
+	assert.EqualValues(stat["futurama.Scheduler.nbTriggered"], 2)
+	assert.EqualValues(stat["futurama.Scheduler.nbDelayed"], 3)
+	assert.EqualValues(stat["futurama.Scheduler.nbSpread"], 1)
+	assert.EqualValues(stat["futurama.Scheduler.nbSkipped"], 1)
+	assert.EqualValues(stat["futurama.Scheduler.nbDeadLetter"], 1)
+	assert.EqualValues(stat["futurama.Scheduler.nbRecovered"], 0)
+}
+
+func TestScheduler_Late_PoolWait(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxConcurrency = 1
+	cfg.TriggerTypes = map[string]*TriggerTypeConfig{
+		Test_TriggerType_Slow: {LatePolicy: LatePolicy_SKIP, LateThresholdMSec: 100},
+	}
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	c := make(chan string, 64)
+	q, _ := CreateQueue(cfg, map[string]TriggerInterface{
+		Test_TriggerType_Slow: ContextTriggerFunc(func(ctx context.Context, ev *Event) *TriggerResult {
+			c <- ev.Id
+			time.Sleep(300 * time.Millisecond)
+			return &TriggerResult{Status: EventStatus_OK}
+		}),
+	})
+	q.Start()
+	defer q.Stop()
+	assert := assert.New(t)
+
+	// the second one waits for the first one to complete, on time nonetheless
+	triggerTime := time.Now().Add(time.Second)
+	q.Create(Test_TriggerType_Slow, triggerTime, "")
+	q.Create(Test_TriggerType_Slow, triggerTime, "")
+	for i := 0; i < 2; i++ {
+		select {
+		case <-c:
+		case <-time.After(3 * time.Second):
+			assert.Fail("event waiting for a worker is not triggered")
+		}
+	}
+	stat := q.GetStat()
+	assert.EqualValues(0, stat["futurama.Scheduler.nbDelayed"])
+	assert.EqualValues(0, stat["futurama.Scheduler.nbSkipped"])
+}
+
+func TestScheduler_Late_TriggerTypeConfig(t *testing.T) {
+	def := &DefaultConfig().TriggerTypeDefault
+	assert := assert.New(t)
+
+	typeCfg := (&TriggerTypeConfig{LatePolicy: LatePolicy_SKIP}).withDefault(def)
+	assert.Equal(LatePolicy_SKIP, typeCfg.LatePolicy)
+	assert.Equal(def.LateThresholdMSec, typeCfg.LateThresholdMSec)
+	assert.Equal(def.LateSpreadMSec, typeCfg.LateSpreadMSec)
+}
@@ -0,0 +1,316 @@
+package futurama
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const Test_TriggerType_Slow = "test-slow"
+
+func TestScheduler_Lease_LongRunningTrigger(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ConsumerLockTimeoutSec = 2
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	c := make(chan string, 64)
+	q, _ := CreateQueue(cfg, map[string]TriggerInterface{
+		// runs longer than the lock timeout
+		Test_TriggerType_Slow: ContextTriggerFunc(func(ctx context.Context, ev *Event) *TriggerResult {
+			c <- ev.Id
+			time.Sleep(5 * time.Second)
+			return &TriggerResult{Status: EventStatus_OK}
+		}),
+	})
+	q.Start()
+	defer q.Stop()
+	assert := assert.New(t)
+
+	evId := q.Create(Test_TriggerType_Slow, time.Now().Add(time.Second), "")
+	select {
+	case id := <-c:
+		assert.Equal(evId, id)
+	case <-time.After(2 * time.Second):
+		assert.FailNow("event is not triggered")
+	}
+
+	time.Sleep(3500 * time.Millisecond)
+	evList := TestOnly_SelectEvents(&cfg.MySQLConfig)
+	assert.Len(evList, 1)
+	assert.NotEqual("", evList[0].Owner)
+
+	// not reset and triggered again while running
+	select {
+	case <-c:
+		assert.Fail("event is triggered twice")
+	case <-time.After(2 * time.Second):
+	}
+	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 0)
+
+	stat := q.GetStat()
+	assert.True(stat["futurama.Scheduler.nbRenewed"].(int32) > 0)
+	assert.EqualValues(1, stat["futurama.Scheduler.nbTriggered"])
+}
+
+func TestScheduler_Lease_Shutdown(t *testing.T) {
+	cfg := DefaultConfig()
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	c := make(chan string, 64)
+	q, _ := CreateQueue(cfg, map[string]TriggerInterface{
+		// runs for 500ms, or until interrupted if the event data is true
+		Test_TriggerType_Slow: ContextTriggerFunc(func(ctx context.Context, ev *Event) *TriggerResult {
+			c <- ev.Id
+			if ev.Data == true {
+				<-ctx.Done()
+				return &TriggerResult{Status: EventStatus_ERROR}
+			}
+			time.Sleep(500 * time.Millisecond)
+			return &TriggerResult{Status: EventStatus_OK}
+		}),
+	})
+	q.Start()
+	assert := assert.New(t)
+
+	q.Create(Test_TriggerType_Slow, time.Now().Add(500*time.Millisecond), "")
+	laterId := q.Create(Test_TriggerType_Slow, time.Now().Add(4*time.Second), "")
+	select {
+	case <-c:
+	case <-time.After(2 * time.Second):
+		assert.FailNow("event is not triggered")
+	}
+
+	// the running trigger completes, the scheduled event is released
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	assert.NoError(q.Shutdown(ctx))
+	evList := TestOnly_SelectEvents(&cfg.MySQLConfig)
+	assert.Len(evList, 1)
+	assert.Equal(laterId, evList[0].Id)
+	assert.Equal("", evList[0].Owner)
+	stat := q.GetStat()
+	assert.EqualValues(1, stat["futurama.Scheduler.nbTriggered"])
+	assert.EqualValues(1, stat["futurama.Scheduler.nbReleased"])
+
+	// the running trigger is interrupted and released as well
+	q.Start()
+	interruptedId := q.Create(Test_TriggerType_Slow, time.Now().Add(500*time.Millisecond), true)
+	select {
+	case id := <-c:
+		assert.Equal(interruptedId, id)
+	case <-time.After(2 * time.Second):
+		assert.FailNow("event is not triggered")
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	assert.Equal(context.DeadlineExceeded, q.Shutdown(ctx))
+	evList = TestOnly_SelectEvents(&cfg.MySQLConfig)
+	assert.Len(evList, 2)
+	for _, ev := range evList {
+		assert.Equal("", ev.Owner)
+	}
+	stat = q.GetStat()
+	assert.EqualValues(1, stat["futurama.Scheduler.nbInterrupted"])
+}
+
+func TestScheduler_Lease_ShutdownGrace(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ShutdownGraceMSec = 500
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	c := make(chan string, 64)
+	q, _ := CreateQueue(cfg, map[string]TriggerInterface{
+		// ignores the interruption, runs for the duration in msec given by the event data
+		Test_TriggerType_Slow: ContextTriggerFunc(func(ctx context.Context, ev *Event) *TriggerResult {
+			c <- ev.Id
+			msec, _ := ev.Data.(json.Number).Int64()
+			time.Sleep(time.Duration(msec) * time.Millisecond)
+			return &TriggerResult{Status: EventStatus_OK}
+		}),
+	})
+	assert := assert.New(t)
+	shutdown := func(data int) []*Event {
+		q.Start()
+		q.Create(Test_TriggerType_Slow, time.Now().Add(500*time.Millisecond), data)
+		select {
+		case <-c:
+		case <-time.After(2 * time.Second):
+			assert.FailNow("event is not triggered")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		assert.Equal(context.DeadlineExceeded, q.Shutdown(ctx))
+		return TestOnly_SelectEvents(&cfg.MySQLConfig)
+	}
+
+	// completed within the grace period, before the store is closed
+	assert.Len(shutdown(300), 0)
+
+	// still running after the grace period, not released
+	evList := shutdown(1500)
+	assert.Len(evList, 1)
+	assert.NotEqual("", evList[0].Owner)
+	// by the first shutdown only
+	assert.EqualValues(1, q.GetStat()["futurama.Scheduler.nbReleased"])
+}
+
+type TestTrigger_Sleep struct {
+	c        chan string
+	duration time.Duration
+}
+
+func (self *TestTrigger_Sleep) Trigger(ev *Event) *TriggerResult {
+	self.c <- ev.Id
+	time.Sleep(self.duration)
+	return &TriggerResult{Status: EventStatus_RETRY}
+}
+
+func TestScheduler_Lease_ShutdownLegacy(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ShutdownGraceMSec = 500
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	c := make(chan string, 64)
+	q, _ := CreateQueue(cfg, map[string]TriggerInterface{
+		Test_TriggerType_Slow: &TestTrigger_Sleep{c, 1500 * time.Millisecond},
+	})
+	q.Start()
+	assert := assert.New(t)
+
+	evId := q.Create(Test_TriggerType_Slow, time.Now().Add(500*time.Millisecond), "")
+	select {
+	case <-c:
+	case <-time.After(2 * time.Second):
+		assert.FailNow("event is not triggered")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Equal(context.DeadlineExceeded, q.Shutdown(ctx))
+
+	// a trigger without context is not abandoned, its event is neither released nor retried while running
+	evList := TestOnly_SelectEvents(&cfg.MySQLConfig)
+	assert.Len(evList, 1)
+	assert.Equal(evId, evList[0].Id)
+	assert.NotEqual("", evList[0].Owner)
+	assert.Equal(0, evList[0].Attempts)
+	assert.EqualValues(0, q.GetStat()["futurama.Scheduler.nbReleased"])
+}
+
+func TestScheduler_Lease_StoreError(t *testing.T) {
+	scheduler := newScheduler(DefaultConfig(), nil)
+	ev := NewEvent(Test_TriggerType_Default, time.Now(), nil)
+	assert := assert.New(t)
+
+	scheduler.stored(ev, nil)
+	scheduler.stored(ev, ErrLostOwnership)
+	scheduler.stored(ev, fmt.Errorf("Connection refused"))
+	stat := scheduler.GetStat(true)
+	assert.EqualValues(1, stat["nbLostOwnership"])
+	assert.EqualValues(1, stat["nbStoreError"])
+	assert.EqualValues(0, scheduler.GetStat(false)["nbStoreError"])
+}
+
+func TestScheduler_Lease_Full(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxScheduledEvents = 2
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	c := make(chan string, 64)
+	q, _ := CreateQueue(cfg, map[string]TriggerInterface{
+		Test_TriggerType_Default: &TestTrigger_Schedule{c},
+	})
+	q.Start()
+	defer q.Stop()
+	assert := assert.New(t)
+
+	// no more events are claimed than the scheduler can take
+	triggerTime := time.Now().Add(3 * time.Second)
+	for i := 0; i < 3; i++ {
+		q.Create(Test_TriggerType_Default, triggerTime.Add(time.Duration(i)*time.Second), "")
+	}
+	time.Sleep(500 * time.Millisecond)
+	owned := 0
+	for _, ev := range TestOnly_SelectEvents(&cfg.MySQLConfig) {
+		if ev.Owner != "" {
+			owned++
+		}
+	}
+	assert.Equal(2, owned)
+	assert.Equal(0, q.scheduler.capacity())
+
+	// an event claimed over the capacity evicts the latest one, which is released
+	store := q.Store.(*MySQLStore)
+	earlier := NewEvent(Test_TriggerType_Default, time.Now().Add(2*time.Second), "")
+	earlierId := store.Save(earlier)
+	err, events := store.getEvents(1, "other", 10, nil)
+	assert.NoError(err)
+	assert.Len(events, 2)
+	for _, ev := range events {
+		if ev.Id == earlierId {
+			q.scheduler.add(ev)
+		} else {
+			store.ReleaseOwnership("other", []string{ev.Id})
+		}
+	}
+	stat := q.GetStat()
+	assert.EqualValues(1, stat["futurama.Scheduler.nbEvicted"])
+	assert.True(stat["futurama.MySQLConsumer.nbFull"].(int32) > 0)
+
+	// all are triggered once there is room
+	triggered := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		select {
+		case id := <-c:
+			triggered[id] = true
+		case <-time.After(10 * time.Second):
+			assert.FailNow("event is not triggered")
+		}
+	}
+	assert.Len(triggered, 4)
+}
+
+func TestScheduler_Lease_LocalClaim(t *testing.T) {
+	cfg := DefaultConfig()
+	// too slow for events created now to be triggered in time after a poll
+	cfg.ConsumerSleepMSec = 2000
+	cfg.ConsumerMaxSleepMSec = 2000
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	c := make(chan *Event, 64)
+	q, _ := CreateQueue(cfg, map[string]TriggerInterface{
+		Test_TriggerType_Slow: ContextTriggerFunc(func(ctx context.Context, ev *Event) *TriggerResult {
+			c <- ev
+			return &TriggerResult{Status: EventStatus_OK}
+		}),
+	})
+	q.Start()
+	defer q.Stop()
+	assert := assert.New(t)
+	time.Sleep(100 * time.Millisecond)
+
+	triggerTime := time.Now().Add(200 * time.Millisecond)
+	evId := q.Create(Test_TriggerType_Slow, triggerTime, map[string]interface{}{"a": 1})
+	laterId := q.Create(Test_TriggerType_Slow, time.Now().Add(time.Hour), "")
+	evList := TestOnly_SelectEvents(&cfg.MySQLConfig)
+	assert.Len(evList, 2)
+	for _, ev := range evList {
+		if ev.Id == laterId {
+			assert.Equal("", ev.Owner)
+		} else {
+			assert.NotEqual("", ev.Owner)
+		}
+	}
+
+	select {
+	case ev := <-c:
+		assert.Equal(evId, ev.Id)
+		assert.WithinDuration(triggerTime, time.Now(), 100*time.Millisecond)
+		// as read from the store
+		assert.Equal(json.Number("1"), ev.Data.(map[string]interface{})["a"])
+	case <-time.After(time.Second):
+		assert.FailNow("event is not triggered")
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 1)
+
+	stat := q.GetStat()
+	assert.EqualValues(1, stat["futurama.MySQLConsumer.nbClaimed"])
+	assert.EqualValues(0, stat["futurama.Scheduler.nbLostOwnership"])
+}
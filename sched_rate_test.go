@@ -0,0 +1,44 @@
+package futurama
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestScheduler_RateLimit(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TriggerTypes = map[string]*TriggerTypeConfig{
+		Test_TriggerType_Default: {RateLimit: 5, RateBurst: 2},
+	}
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	q, testChan := SetupQueue(cfg)
+	defer q.Stop()
+	assert := assert.New(t)
+
+	triggerTime := time.Now().Add(time.Second)
+	for i := 0; i < 6; i++ {
+		q.Create(Test_TriggerType_Default, triggerTime, "")
+	}
+
+	// 2 at once, then one every 200ms
+	var triggered []time.Duration
+	for i := 0; i < 6; i++ {
+		select {
+		case <-testChan:
+			triggered = append(triggered, time.Since(triggerTime))
+		case <-time.After(2 * time.Second):
+			assert.FailNow("event is not triggered")
+		}
+	}
+	assert.InDelta(0, triggered[1], float64(150*time.Millisecond))
+	assert.InDelta(200*time.Millisecond, triggered[2], float64(150*time.Millisecond))
+	assert.InDelta(800*time.Millisecond, triggered[5], float64(150*time.Millisecond))
+
+	time.Sleep(500 * time.Millisecond)
+	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 0)
+
+	stat := q.GetStat()
+	assert.EqualValues(4, stat["futurama.Scheduler.nbThrottled"])
+	assert.EqualValues(0, stat["futurama.Scheduler.nbDelayed"])
+}
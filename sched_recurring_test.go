@@ -0,0 +1,100 @@
+package futurama
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestScheduler_Recurring_AndCancel(t *testing.T) {
+	cfg := DefaultConfig()
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	q, testChan := SetupQueue(cfg)
+	defer q.Stop()
+	assert := assert.New(t)
+
+	_, err := q.CreateRecurring(Test_TriggerType_Default, "not a cron spec", nil)
+	assert.Error(err)
+
+	evId, err := q.CreateRecurring(Test_TriggerType_Default, "*/2 * * * * *", nil)
+	assert.NoError(err)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case id := <-testChan:
+			assert.Equal(id, evId)
+			assert.Equal(0, time.Now().Second()%2)
+		case <-time.After(3 * time.Second):
+			assert.Fail("occurrence is not triggered")
+		}
+	}
+
+	evList := TestOnly_SelectEvents(&cfg.MySQLConfig)
+	assert.Len(evList, 1)
+	assert.Equal(evId, evList[0].Id)
+
+	q.Cancel(evId)
+	select {
+	case <-testChan:
+		// the occurrence might be in-flight while cancelling
+	case <-time.After(2500 * time.Millisecond):
+	}
+	select {
+	case <-testChan:
+		assert.Fail("cancelled series is triggered")
+	case <-time.After(2500 * time.Millisecond):
+	}
+	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 0)
+
+	stat := q.GetStat()
+	assert.EqualValues(stat["futurama.MySQLStore.nbSave"], 1)
+	assert.True(stat["futurama.MySQLStore.nbNext"].(int32) >= 3)
+}
+
+type testTrigger_Occurrence struct {
+	C chan int
+}
+
+func (self *testTrigger_Occurrence) Trigger(ev *Event) *TriggerResult {
+	self.C <- ev.Occurrence
+	return &TriggerResult{Status: EventStatus_OK}
+}
+
+func TestScheduler_Repeating_MaxOccurrences(t *testing.T) {
+	cfg := DefaultConfig()
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	c := make(chan int, 64)
+	q, _ := CreateQueue(cfg, map[string]TriggerInterface{
+		Test_TriggerType_Default: &testTrigger_Occurrence{c},
+	})
+	q.Start()
+	defer q.Stop()
+	assert := assert.New(t)
+
+	_, err := q.CreateRepeating(Test_TriggerType_Default, time.Now(), 0, 0, time.Time{}, nil)
+	assert.Error(err)
+
+	triggerTime := time.Now().Add(time.Second)
+	_, err = q.CreateRepeating(Test_TriggerType_Default, triggerTime, time.Second, 3, time.Time{}, nil)
+	assert.NoError(err)
+
+	for i := 1; i <= 3; i++ {
+		select {
+		case occurrence := <-c:
+			assert.Equal(i, occurrence)
+			assert.WithinDuration(time.Now(), triggerTime.Add(time.Duration(i-1)*time.Second), 150*time.Millisecond)
+		case <-time.After(2 * time.Second):
+			assert.Fail("occurrence is not triggered")
+		}
+	}
+	select {
+	case <-c:
+		assert.Fail("triggered more than MaxOccurrences")
+	case <-time.After(2 * time.Second):
+	}
+	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 0)
+
+	stat := q.GetStat()
+	assert.EqualValues(stat["futurama.MySQLStore.nbNext"], 2)
+	assert.EqualValues(stat["futurama.MySQLStore.nbComplete"], 1)
+}
@@ -84,10 +84,126 @@ func TestScheduler_Retry_Giveup(t *testing.T) {
 	time.Sleep(500 * time.Millisecond)
 	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 0)
 
+	deadLetters, err := q.ListDeadLetters(nil, 0)
+	assert.NoError(err)
+	assert.Len(deadLetters, 1)
+	assert.Equal(evId, deadLetters[0].Id)
+	assert.Equal(cfg.MaxRetry+1, deadLetters[0].Attempts)
+
 	stat := q.GetStat()
-	assert.EqualValues(1, stat["futurama.MySQLStore.nbComplete"])
+	assert.EqualValues(0, stat["futurama.MySQLStore.nbComplete"])
+	assert.EqualValues(1, stat["futurama.MySQLStore.nbDeadLetter"])
 	assert.EqualValues(1, stat["futurama.MySQLStore.nbSave"])
 	assert.EqualValues(5, stat["futurama.MySQLStore.nbRetry"])
 	assert.EqualValues(6, stat["futurama.Scheduler.nbTriggered"])
 	assert.EqualValues(1, stat["futurama.Scheduler.nbGiveup"])
 }
+
+func TestScheduler_Retry_TriggerTypePolicy(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxRetry = 5
+	cfg.TriggerTypes = map[string]*TriggerTypeConfig{
+		Test_TriggerType_Retry: {
+			MaxRetry:          2,
+			RetryIntervalMSec: 100,
+			RetryJitter:       RetryJitter_NONE,
+		},
+	}
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	q, testChan := SetupQueue(cfg)
+	defer q.Stop()
+	assert := assert.New(t)
+
+	triggerTime := time.Now().Add(time.Second)
+	evId := q.Create(Test_TriggerType_Retry, triggerTime, &RetryData{100, 0})
+
+	// retried after 100ms then 200ms
+	for _, retryTime := range []time.Time{
+		triggerTime,
+		triggerTime.Add(100 * time.Millisecond),
+		triggerTime.Add(300 * time.Millisecond),
+	} {
+		select {
+		case id := <-testChan:
+			assert.Equal(evId, id)
+			assert.WithinDuration(retryTime, time.Now(), 150*time.Millisecond)
+		case <-time.After(3 * time.Second):
+			assert.Fail("timeout")
+		}
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 0)
+
+	stat := q.GetStat()
+	assert.EqualValues(2, stat["futurama.MySQLStore.nbRetry"])
+	assert.EqualValues(3, stat["futurama.Scheduler.nbTriggered"])
+	assert.EqualValues(1, stat["futurama.Scheduler.nbGiveup"])
+}
+
+func TestScheduler_Retry_MaxElapsed(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TriggerTypeDefault.RetryIntervalMSec = 400
+	cfg.TriggerTypeDefault.RetryJitter = RetryJitter_NONE
+	cfg.TriggerTypeDefault.RetryMaxElapsedMSec = 1000
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	q, testChan := SetupQueue(cfg)
+	defer q.Stop()
+	assert := assert.New(t)
+
+	evId := q.Create(Test_TriggerType_Retry, time.Now().Add(time.Second), &RetryData{100, 0})
+
+	// retried after 400ms, the next retry 800ms later would be 1200ms after the first attempt
+	for i := 0; i < 2; i++ {
+		select {
+		case id := <-testChan:
+			assert.Equal(evId, id)
+		case <-time.After(3 * time.Second):
+			assert.Fail("timeout")
+		}
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 0)
+
+	stat := q.GetStat()
+	assert.EqualValues(1, stat["futurama.MySQLStore.nbRetry"])
+	assert.EqualValues(1, stat["futurama.Scheduler.nbGiveup"])
+}
+
+func TestScheduler_Retry_BackoffPolicy(t *testing.T) {
+	cfg := DefaultConfig()
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	q, testChan := SetupQueue(cfg)
+	defer q.Stop()
+	assert := assert.New(t)
+
+	q.SetBackoffPolicy(Test_TriggerType_Retry, &FixedBackoff{Interval: 300 * time.Millisecond})
+	assert.NoError(q.RegisterBackoffPolicy("linear", &LinearBackoff{Interval: time.Second}))
+	assert.Error(q.RegisterBackoffPolicy("", &FixedBackoff{}))
+
+	triggerTime := time.Now().Add(time.Second)
+	evId1 := q.Create(Test_TriggerType_Retry, triggerTime, &RetryData{1, 0})
+	ev := NewEvent(Test_TriggerType_Retry, triggerTime, &RetryData{1, 0})
+	ev.BackoffPolicy = "linear"
+	evId2 := q.CreateEvent(ev)
+
+	retryTimes := map[string]time.Time{
+		evId1: triggerTime.Add(300 * time.Millisecond),
+		evId2: triggerTime.Add(time.Second),
+	}
+	for i := 0; i < 4; i++ {
+		select {
+		case id := <-testChan:
+			if time.Now().After(triggerTime.Add(200 * time.Millisecond)) {
+				assert.WithinDuration(retryTimes[id], time.Now(), 150*time.Millisecond)
+			}
+		case <-time.After(3 * time.Second):
+			assert.Fail("timeout")
+		}
+	}
+
+	stat := q.GetStat()
+	assert.EqualValues(2, stat["futurama.MySQLStore.nbRetry"])
+	assert.EqualValues(4, stat["futurama.Scheduler.nbTriggered"])
+}
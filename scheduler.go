@@ -1,7 +1,9 @@
 package futurama
 
 import (
+	"context"
 	"github.com/golang/glog"
+	"math/rand"
 	"runtime/debug"
 	"sync"
 	"time"
@@ -13,44 +15,303 @@ type SchedulerDepsContainer struct {
 
 var SchedulerDeps = &SchedulerDepsContainer{}
 
+// what to do with events triggered later than TriggerTypeConfig.LateThresholdMSec
+const (
+	LatePolicy_TRIGGER     = "trigger"     // trigger now
+	LatePolicy_SKIP        = "skip"        // complete as SKIPPED without triggering
+	LatePolicy_DEAD_LETTER = "dead_letter" // move to dead letters without triggering
+	LatePolicy_SPREAD      = "spread"      // reschedule randomly within LateSpreadMSec from now
+)
+
+type inflightEvent struct {
+	ev     *Event
+	cancel context.CancelFunc // nil if the trigger can not be interrupted
+}
+
 type Scheduler struct {
 	SchedulerDepsContainer `inject:"inline"`
 
 	eventMutex sync.RWMutex
-	events     *PQ
-	maxRetry   int
-	triggers   map[string]TriggerInterface
+	events     eventTimers
+	pool       *workerPool
+	// events are neither scheduled nor triggered until started, and once shut down
+	closed bool
+
+	// triggers wrapped by middlewares
+	triggerMutex sync.RWMutex
+	triggers     map[string]ContextTriggerInterface
+	wrapped      map[string]ContextTriggerInterface
+	// trigger types whose triggers take a context, others are called without one as they can not be interrupted
+	contextTypes map[string]bool
+	noTrigger    ContextTriggerInterface
+	middlewares  []TriggerMiddleware
+
+	// contexts of running triggers, ctx is cancelled by shutdown
+	inflightMutex sync.Mutex
+	inflight      map[string]*inflightEvent
+	ctx           context.Context
+	stop          context.CancelFunc
+	shutdownGrace time.Duration
+
+	missedPolicy       string
+	triggerTypeDefault *TriggerTypeConfig
+	triggerTypes       map[string]*TriggerTypeConfig
+
+	// set by Queue.SetBackoffPolicy (by trigger type) and Queue.RegisterBackoffPolicy (by name)
+	backoffMutex         sync.RWMutex
+	backoffPolicies      map[string]BackoffPolicy
+	namedBackoffPolicies map[string]BackoffPolicy
+
+	// token buckets of trigger types with RateLimit
+	rateMutex    sync.Mutex
+	rateLimiters map[string]*tokenBucket
 
 	nbDelayed   Seq32
 	nbTriggered Seq32
 	nbGiveup    Seq32
 	nbRecovered Seq32
+	nbSkipped   Seq32
+	nbExpired   Seq32
+
+	nbSpread     Seq32
+	nbDeadLetter Seq32
+
+	nbTimeout     Seq32
+	nbInterrupted Seq32
+	nbThrottled   Seq32
+
+	nbPending    Seq32
+	nbAcked      Seq32
+	nbAckTimeout Seq32
+
+	nbLostOwnership Seq32
+	nbStoreError    Seq32
+
+	// ownership of scheduled and running events is renewed every renewInterval
+	renewInterval time.Duration
+	renewQuit     chan bool
+	nbRenewed     Seq32
+	nbReleased    Seq32
+	nbEvicted     Seq32
 }
 
 func newScheduler(cfg *Config, triggers map[string]TriggerInterface) *Scheduler {
-	return &Scheduler{
-		events:   NewPQ(true, cfg.MaxScheduledEvents),
-		maxRetry: cfg.MaxRetry,
-		triggers: triggers,
+	triggerTypeDefault := cfg.TriggerTypeDefault
+	if triggerTypeDefault.MaxRetry == 0 {
+		triggerTypeDefault.MaxRetry = cfg.MaxRetry
+	}
+	triggerTypes := make(map[string]*TriggerTypeConfig)
+	for triggerType, typeCfg := range cfg.TriggerTypes {
+		triggerTypes[triggerType] = typeCfg.withDefault(&triggerTypeDefault)
+	}
+
+	contextTriggers := make(map[string]ContextTriggerInterface)
+	contextTypes := make(map[string]bool)
+	for triggerType, trigger := range triggers {
+		contextTriggers[triggerType] = WithContext(trigger)
+		if _, ok := trigger.(ContextTriggerInterface); ok {
+			contextTypes[triggerType] = true
+		}
+	}
+	ctx, stop := context.WithCancel(context.Background())
+
+	scheduler := &Scheduler{
+		closed: true,
+
+		triggers:     contextTriggers,
+		wrapped:      contextTriggers,
+		contextTypes: contextTypes,
+		noTrigger:    noTrigger,
+
+		inflight:      make(map[string]*inflightEvent),
+		ctx:           ctx,
+		stop:          stop,
+		shutdownGrace: time.Duration(cfg.ShutdownGraceMSec) * time.Millisecond,
+
+		missedPolicy:       cfg.CronMissedPolicy,
+		triggerTypeDefault: &triggerTypeDefault,
+		triggerTypes:       triggerTypes,
+
+		backoffPolicies:      make(map[string]BackoffPolicy),
+		namedBackoffPolicies: make(map[string]BackoffPolicy),
+
+		rateLimiters: make(map[string]*tokenBucket),
+
+		renewInterval: time.Duration(cfg.ConsumerLockTimeoutSec) * time.Second / 3,
+	}
+	scheduler.pool = newWorkerPool(cfg.MaxConcurrency, func(triggerType string) int {
+		return scheduler.getTriggerTypeConfig(triggerType).MaxConcurrency
+	}, scheduler.run)
+	scheduler.events = newEventTimers(&cfg.SchedulerConfig, scheduler.fire)
+	return scheduler
+}
+
+func (self *Scheduler) start() {
+	self.eventMutex.Lock()
+	self.closed = false
+	self.events.start()
+	self.eventMutex.Unlock()
+
+	if _, ok := self.Store.(LeaseStoreInterface); !ok || self.renewInterval <= 0 {
+		return
+	}
+	glog.Infoln("Renew ownership every", self.renewInterval)
+	quit := make(chan bool)
+	self.renewQuit = quit
+	go func() {
+		ticker := time.NewTicker(self.renewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				self.renew()
+			}
+		}
+	}()
+}
+
+func (self *Scheduler) stopRenew() {
+	if self.renewQuit != nil {
+		close(self.renewQuit)
+		self.renewQuit = nil
+	}
+}
+
+// renew extends ownership of scheduled (including waiting for a worker) and running events,
+// so that consumers only reset events of lost instances
+func (self *Scheduler) renew() {
+	store := self.Store.(LeaseStoreInterface)
+	evIds := make(map[string][]string)
+
+	self.eventMutex.RLock()
+	for _, ev := range self.events.events() {
+		evIds[ev.Owner] = append(evIds[ev.Owner], ev.Id)
+	}
+	self.eventMutex.RUnlock()
+
+	self.inflightMutex.Lock()
+	for _, running := range self.inflight {
+		evIds[running.ev.Owner] = append(evIds[running.ev.Owner], running.ev.Id)
+	}
+	self.inflightMutex.Unlock()
+
+	for owner, ids := range evIds {
+		if owner == "" {
+			continue
+		}
+		n, err := store.RenewOwnership(owner, ids)
+		if err != nil {
+			glog.Errorln("RenewOwnership:", err, owner)
+			continue
+		}
+		self.nbRenewed.Next()
+		if glog.V(2) {
+			glog.Infof("Renewed ownership of %d/%d events owned by %s", n, len(ids), owner)
+		}
+	}
+}
+
+// shutdown stops triggering scheduled events and waits for running triggers until ctx is done, then interrupts
+// the ones still running and waits for them up to shutdownGrace. Ownership of events left is released for
+// other instances to take them at once, except events whose trigger is still running: they are left
+// to be reset after the lock timeout, not to be triggered twice at once
+func (self *Scheduler) shutdown(ctx context.Context) error {
+	glog.Infoln("Shutdown")
+	self.eventMutex.Lock()
+	self.closed = true
+	self.eventMutex.Unlock()
+	self.pool.clear()
+
+	// ownership of running events is renewed while draining
+	err := self.drain(ctx)
+
+	// interrupt running triggers, a new context is kept for restarting
+	self.inflightMutex.Lock()
+	self.stop()
+	self.ctx, self.stop = context.WithCancel(context.Background())
+	interrupted := make([]*Event, 0, len(self.inflight))
+	for _, running := range self.inflight {
+		interrupted = append(interrupted, running.ev)
+	}
+	self.inflightMutex.Unlock()
+
+	left := make([]*Event, 0, len(interrupted))
+	if len(interrupted) > 0 {
+		glog.Warningf("Interrupted %d running triggers: %s", len(interrupted), err)
+		grace, cancel := context.WithTimeout(context.Background(), self.shutdownGrace)
+		self.drain(grace)
+		cancel()
+
+		self.inflightMutex.Lock()
+		for _, ev := range interrupted {
+			if _, running := self.inflight[ev.Id]; running {
+				glog.Warningf("%s Trigger still running, left owned until the lock timeout", ev)
+			} else {
+				left = append(left, ev)
+			}
+		}
+		self.inflightMutex.Unlock()
 	}
+	self.stopRenew()
+
+	self.pool.clear()
+	self.release(append(left, self.unschedule()...))
+	self.eventMutex.Lock()
+	self.events.stop()
+	self.eventMutex.Unlock()
+	return err
 }
 
-func (self *Scheduler) clear() {
-	glog.Infoln("Clear")
+// drain waits until no trigger is running, or until ctx is done
+func (self *Scheduler) drain(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for self.pool.nbRunning() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// unschedule removes all scheduled events along with stopping their timers
+func (self *Scheduler) unschedule() []*Event {
 	self.eventMutex.Lock()
 	defer self.eventMutex.Unlock()
 
-	for {
-		item := self.events.Top()
-		if item == nil {
-			return
+	events := self.events.events()
+	for _, ev := range events {
+		glog.Infoln("Unschedule", ev)
+		self.events.remove(ev.Id)
+	}
+	return events
+}
+
+// release gives up ownership of events not completed, for other instances to take them without waiting
+// for the lock timeout
+func (self *Scheduler) release(events []*Event) {
+	store, ok := self.Store.(LeaseStoreInterface)
+	if !ok || len(events) == 0 {
+		return
+	}
+	evIds := make(map[string][]string)
+	for _, ev := range events {
+		if ev.Owner != "" {
+			evIds[ev.Owner] = append(evIds[ev.Owner], ev.Id)
 		}
-		pqItem := item.(PQItem)
-		if removed := self.events.Remove(pqItem.GetKey()); removed != nil {
-			removedEv := removed.(*Event)
-			glog.Infoln("Clear removed", removedEv)
-			removedEv.Stop()
+	}
+	for owner, ids := range evIds {
+		n, err := store.ReleaseOwnership(owner, ids)
+		if err != nil {
+			glog.Errorln("ReleaseOwnership:", err, owner)
+			continue
 		}
+		self.nbReleased.Next()
+		glog.Infof("Released ownership of %d/%d events owned by %s", n, len(ids), owner)
 	}
 }
 
@@ -62,117 +323,518 @@ func (self *Scheduler) add(ev *Event) {
 	glog.Infoln(ev, "Add")
 
 	self.eventMutex.Lock()
-
-	if index := self.events.Lookup(ev.GetKey()); index >= 0 {
+	if self.closed {
 		self.eventMutex.Unlock()
-		if glog.V(2) {
-			glog.Infof("%s Event has been scheduled, index: %d", ev, index)
-		}
+		glog.Infoln(ev, "Scheduler has been shut down, not scheduling")
+		self.release([]*Event{ev})
 		return
 	}
 
-	index, poped := self.events.Push(ev, ev.TriggerTime.UnixNano())
+	if scheduled := self.events.get(ev.Id); scheduled != nil {
+		if scheduled.Status == ev.Status && scheduled.TriggerTime.Equal(ev.TriggerTime) {
+			// taken again after its ownership expired, writes are fenced by the latest ownership
+			scheduled.Owner, scheduled.OwnerSeq = ev.Owner, ev.OwnerSeq
+			self.eventMutex.Unlock()
+			if glog.V(2) {
+				glog.Infof("%s Event has been scheduled", ev)
+			}
+			return
+		}
+		// updated since scheduled, e.g. a PENDING event acknowledged with RETRY: scheduled as stored
+		glog.Infof("%s Replace scheduled event, status: %s trigger time: %s", ev, scheduled.Status, scheduled.TriggerTime)
+		self.events.remove(ev.Id)
+	}
+
+	// late events are triggered at once, and handled by trigger according to LatePolicy
+	evicted := self.events.schedule(ev, ev.TriggerTime)
 	self.eventMutex.Unlock()
 
-	if index >= 0 {
-		du := ev.TriggerTime.Sub(time.Now())
-		if du.Seconds() < -2.0 {
-			glog.Warningf("%s Scheduler is behind ev.TriggerTime: %s, triggering now", ev, du)
-			self.nbDelayed.Next()
-			du = 0
-		}
-		glog.Infof("%s Duration to trigger: %s", ev, du)
-		ev.timer = time.AfterFunc(du, func() {
-			defer func() {
-				if r := recover(); r != nil {
-					glog.Errorf("Recovered in time.AfterFunc, msg: %s ev: %s stack: %s", r, ev, debug.Stack())
-					self.nbRecovered.Next()
-					self.Store.UpdateStatus(ev.Id, EventStatus_ERROR)
-				}
-			}()
-			self.trigger(ev.Id)
-		})
+	if evicted != ev {
+		glog.Infof("%s Duration to trigger: %s", ev, ev.TriggerTime.Sub(time.Now()))
 		glog.Infoln(ev, "Event scheduled")
 	}
-
-	var popedEv *Event = nil
-	if poped != nil {
-		popedEv = poped.(*Event)
-		popedEv.Stop()
-	} else {
-		if index == -1 {
-			popedEv = ev
-		}
+	if evicted != nil {
+		// released to be taken again when there is room, by this or another instance
+		glog.Infof("%s Queue is full, poped event", evicted)
+		self.nbEvicted.Next()
+		self.release([]*Event{evicted})
 	}
+}
 
-	if popedEv != nil {
-		// TODO self.Store.RemoveOwnership(ev.Id)
-		glog.Infof("%s Queue is full, poped event index: %d", popedEv, index)
-	}
+// isOpen returns true if the scheduler has been started and not shut down
+func (self *Scheduler) isOpen() bool {
+	self.eventMutex.RLock()
+	defer self.eventMutex.RUnlock()
+	return !self.closed
+}
+
+// capacity returns the number of events which can be scheduled without evicting any
+func (self *Scheduler) capacity() int {
+	self.eventMutex.RLock()
+	defer self.eventMutex.RUnlock()
+	return self.events.capacity() - self.events.len()
 }
 
 func (self *Scheduler) cancel(ev *Event) {
 	glog.Infoln(ev, "Cancel")
 	self.eventMutex.Lock()
 
-	if removed := self.events.Remove(ev.Id); removed != nil {
-		removedEv := removed.(*Event)
-		removedEv.Stop()
-		glog.Infoln(removedEv, "Cancelled scheduled event")
+	if removed := self.events.remove(ev.Id); removed != nil {
+		glog.Infoln(removed, "Cancelled scheduled event")
 	}
 	self.eventMutex.Unlock()
+	self.interrupt(ev.Id)
 	self.Store.UpdateStatus(ev.Id, EventStatus_CANCEL)
 }
 
-func (self *Scheduler) trigger(evId string) {
-	glog.Infoln(evId, "Trigger")
+// fire submits a due event to the pool
+func (self *Scheduler) fire(ev *Event) {
+	ev.fired = time.Now()
+	self.pool.submit(ev)
+}
+
+// run triggers ev in a worker of the pool
+func (self *Scheduler) run(ev *Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			glog.Errorf("Recovered in trigger, msg: %s ev: %s stack: %s", r, ev, debug.Stack())
+			self.nbRecovered.Next()
+			self.complete(ev, EventStatus_ERROR, nil)
+		}
+	}()
+	self.trigger(ev)
+}
+
+// trigger runs the trigger of a fired event, unless it has been unscheduled or replaced since
+func (self *Scheduler) trigger(ev *Event) {
+	glog.Infoln(ev.Id, "Trigger")
 	self.eventMutex.Lock()
-	removed := self.events.Remove(evId)
-	if removed == nil {
-		glog.Infoln(evId, "Event has been cancelled, not triggering")
+	if self.closed {
+		glog.Infoln(ev.Id, "Scheduler has been shut down, not triggering")
 		self.eventMutex.Unlock()
 		return
 	}
+	if self.events.get(ev.Id) != ev {
+		glog.Infoln(ev.Id, "Event has been cancelled or replaced, not triggering")
+		self.eventMutex.Unlock()
+		return
+	}
+	self.events.remove(ev.Id)
 	self.eventMutex.Unlock()
 
-	ev := removed.(*Event)
+	if ev.Status == EventStatus_PENDING {
+		self.ackTimeout(ev)
+		return
+	}
+	if ev.IsExpired(time.Now()) {
+		glog.Warningf("%s Deadline %s has passed, not triggering", ev, ev.Deadline)
+		self.nbExpired.Next()
+		self.complete(ev, EventStatus_EXPIRED, nil)
+		return
+	}
+	typeCfg := self.getTriggerTypeConfig(ev.TriggerType)
+	if ev.throttled {
+		// already checked before being delayed
+		ev.throttled = false
+	} else {
+		// waiting for a worker is not being late, bursts are absorbed by the pool
+		if late := ev.fired.Sub(ev.TriggerTime); late > time.Duration(typeCfg.LateThresholdMSec)*time.Millisecond {
+			if !self.late(ev, late, typeCfg) {
+				return
+			}
+		}
+		if self.throttle(ev, typeCfg) {
+			return
+		}
+	}
+
 	trigger := self.getTrigger(ev.TriggerType)
+	ev.chained = nil
+	parent, ctx, cancel := self.triggerContext(ev, typeCfg)
+	defer cancel()
 	before := time.Now()
-	result := trigger.Trigger(ev)
+	result := trigger.TriggerContext(ctx, ev)
+	if result == nil {
+		result = &TriggerResult{Status: EventStatus_ERROR}
+	}
 	glog.Infof("%s TriggerResult status: %s took: %s", ev, result.Status, time.Since(before))
 	self.nbTriggered.Next()
 
+	// triggers completing with OK in spite of an interruption are kept as completed
+	if ctx.Err() != nil && result.Status != EventStatus_OK {
+		if parent.Err() != nil {
+			// released by shutdown as scheduled events, to be triggered again
+			glog.Infoln(ev, "Trigger interrupted by stop")
+			self.nbInterrupted.Next()
+			return
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			glog.Warningf("%s Trigger timed out after %dms, retry", ev, typeCfg.TimeoutMSec)
+			self.nbTimeout.Next()
+			result = &TriggerResult{Status: EventStatus_RETRY, Data: result.Data}
+		} else {
+			glog.Infoln(ev, "Trigger interrupted by cancel")
+			self.nbInterrupted.Next()
+			result = &TriggerResult{Status: EventStatus_CANCEL}
+		}
+	}
+
+	self.result(ev, typeCfg, result, before)
+}
+
+// result handles the result of an attempt started at attempted
+func (self *Scheduler) result(ev *Event, typeCfg *TriggerTypeConfig, result *TriggerResult, attempted time.Time) {
 	switch result.Status {
+	case EventStatus_PENDING:
+		store, ok := self.Store.(AckStoreInterface)
+		if !ok {
+			glog.Errorf("%s Store does not support PENDING events", ev)
+			self.complete(ev, EventStatus_ERROR, nil)
+			return
+		}
+		ackTime := result.TriggerTime
+		if ackTime.IsZero() {
+			ackTime = time.Now().Add(time.Duration(typeCfg.AckTimeoutMSec) * time.Millisecond)
+		}
+		ev.reschedule(ackTime)
+		glog.Infof("%s Wait for ack until %s", ev, ev.TriggerTime)
+		self.nbPending.Next()
+		self.stored(ev, store.UpdateForAck(ev, result.Data))
 	case EventStatus_RETRY:
-		if ev.Attempts >= self.maxRetry {
-			glog.Infof("%s reached MaxRetry(%d), give up", ev, self.maxRetry)
+		if ev.FirstAttempt.IsZero() {
+			ev.FirstAttempt = attempted
+		}
+		if ev.Attempts >= typeCfg.MaxRetry {
+			glog.Infof("%s reached MaxRetry(%d), give up", ev, typeCfg.MaxRetry)
 			self.nbGiveup.Next()
-			self.Store.UpdateStatus(ev.Id, EventStatus_ERROR)
-		} else {
-			if result.TriggerTime.IsZero() {
-				ev.TriggerTime = backoff(ev.Attempts)
+			self.deadLetter(ev, result.Data)
+			return
+		}
+		retryTime := result.TriggerTime
+		if retryTime.IsZero() {
+			retryTime = time.Now().Add(self.getBackoffPolicy(ev, typeCfg).Backoff(ev.Attempts))
+		}
+		maxElapsed := time.Duration(typeCfg.RetryMaxElapsedMSec) * time.Millisecond
+		if maxElapsed > 0 && retryTime.Sub(ev.FirstAttempt) > maxElapsed {
+			glog.Infof("%s Retry would be after RetryMaxElapsedMSec(%d), give up", ev, typeCfg.RetryMaxElapsedMSec)
+			self.nbGiveup.Next()
+			self.deadLetter(ev, result.Data)
+			return
+		}
+		if ev.IsExpired(retryTime) {
+			glog.Infof("%s Retry would be after deadline %s, expire", ev, ev.Deadline)
+			self.nbExpired.Next()
+			self.complete(ev, EventStatus_EXPIRED, nil)
+			return
+		}
+		ev.reschedule(retryTime)
+		ev.Attempts++
+		self.stored(ev, self.Store.UpdateForRetry(ev, result.Data))
+	default:
+		self.complete(ev, result.Status, ev.chained)
+	}
+}
+
+// ackTimeout retries ev which has not been acknowledged until its ack deadline
+func (self *Scheduler) ackTimeout(ev *Event) {
+	store, ok := self.Store.(AckStoreInterface)
+	if !ok {
+		return
+	}
+	pending, err := store.Ack(ev.Id)
+	if err != nil || pending == nil {
+		glog.Infoln(ev, "Event has been acknowledged")
+		return
+	}
+	glog.Warningf("%s Not acknowledged until %s, retry", pending, ev.TriggerTime)
+	self.nbAckTimeout.Next()
+	self.result(pending, self.getTriggerTypeConfig(pending.TriggerType),
+		&TriggerResult{Status: EventStatus_RETRY}, time.Now())
+}
+
+// ack completes a PENDING event taken by AckStoreInterface.Ack with the given result
+func (self *Scheduler) ack(ev *Event, result *TriggerResult) {
+	glog.Infof("%s Acknowledged status: %s", ev, result.Status)
+	self.nbAcked.Next()
+	self.result(ev, self.getTriggerTypeConfig(ev.TriggerType), result, time.Now())
+}
+
+// triggerContext returns the context running triggers (done on clear) and the context of ev
+// registered to be cancelled by interrupt, its cancel func must be called once triggered.
+// Triggers without context are registered as running, but get a context which is never done
+func (self *Scheduler) triggerContext(ev *Event, typeCfg *TriggerTypeConfig) (context.Context, context.Context, context.CancelFunc) {
+	self.inflightMutex.Lock()
+	defer self.inflightMutex.Unlock()
+
+	if !self.contextTypes[ev.TriggerType] {
+		self.inflight[ev.Id] = &inflightEvent{ev, nil}
+		return context.Background(), context.Background(), func() {
+			self.inflightMutex.Lock()
+			delete(self.inflight, ev.Id)
+			self.inflightMutex.Unlock()
+		}
+	}
+
+	parent := self.ctx
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if typeCfg.TimeoutMSec > 0 {
+		ctx, cancel = context.WithTimeout(parent, time.Duration(typeCfg.TimeoutMSec)*time.Millisecond)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+	self.inflight[ev.Id] = &inflightEvent{ev, cancel}
+
+	return parent, ctx, func() {
+		self.inflightMutex.Lock()
+		delete(self.inflight, ev.Id)
+		self.inflightMutex.Unlock()
+		cancel()
+	}
+}
+
+// interrupt cancels the context of a running trigger
+func (self *Scheduler) interrupt(evId string) {
+	self.inflightMutex.Lock()
+	running, ok := self.inflight[evId]
+	self.inflightMutex.Unlock()
+	if ok && running.cancel != nil {
+		glog.Infoln(evId, "Interrupt running trigger")
+		running.cancel()
+	}
+}
+
+// interruptCancelled interrupts running triggers of events cancelled in the store, e.g. in bulk
+func (self *Scheduler) interruptCancelled() {
+	store, ok := self.Store.(CancelledStoreInterface)
+	if !ok {
+		return
+	}
+	self.inflightMutex.Lock()
+	evIds := make([]string, 0, len(self.inflight))
+	for evId, running := range self.inflight {
+		if running.cancel != nil {
+			evIds = append(evIds, evId)
+		}
+	}
+	self.inflightMutex.Unlock()
+	if len(evIds) == 0 {
+		return
+	}
+
+	cancelled, err := store.Cancelled(evIds)
+	if err != nil {
+		glog.Errorln("Cancelled:", err)
+	}
+	for _, evId := range cancelled {
+		self.interrupt(evId)
+	}
+}
+
+// throttle delays ev if its trigger type is over its RateLimit, returns true if delayed
+func (self *Scheduler) throttle(ev *Event, typeCfg *TriggerTypeConfig) bool {
+	if typeCfg.RateLimit <= 0 {
+		return false
+	}
+	self.rateMutex.Lock()
+	limiter, ok := self.rateLimiters[ev.TriggerType]
+	if !ok {
+		limiter = newTokenBucket(typeCfg.RateLimit, typeCfg.RateBurst)
+		self.rateLimiters[ev.TriggerType] = limiter
+	}
+	self.rateMutex.Unlock()
+
+	delay := limiter.reserve(time.Now())
+	if delay <= 0 {
+		return false
+	}
+	glog.Infof("%s Over rate limit of %s, delayed: %s", ev, ev.TriggerType, delay)
+	self.nbThrottled.Next()
+
+	// scheduled again to be cancellable while delayed, keeping its trigger time
+	self.eventMutex.Lock()
+	ev.throttled = true
+	evicted := self.events.schedule(ev, time.Now().Add(delay))
+	if evicted == ev {
+		ev.throttled = false
+	}
+	self.eventMutex.Unlock()
+
+	if evicted != nil {
+		glog.Infof("%s Queue is full, poped event", evicted)
+		self.nbEvicted.Next()
+		self.release([]*Event{evicted})
+	}
+	return true
+}
+
+// late applies LatePolicy to a late event, returns true if it should be triggered now
+func (self *Scheduler) late(ev *Event, late time.Duration, typeCfg *TriggerTypeConfig) bool {
+	self.nbDelayed.Next()
+
+	policy := typeCfg.LatePolicy
+	if ev.IsRecurring() && self.missedPolicy == MissedPolicy_SKIP {
+		policy = LatePolicy_SKIP
+	}
+
+	switch policy {
+	case LatePolicy_SKIP:
+		glog.Warningf("%s Scheduler is behind ev.TriggerTime: %s, skip", ev, late)
+		self.nbSkipped.Next()
+		self.complete(ev, EventStatus_SKIPPED, nil)
+	case LatePolicy_DEAD_LETTER:
+		glog.Warningf("%s Scheduler is behind ev.TriggerTime: %s, move to dead letter", ev, late)
+		self.nbDeadLetter.Next()
+		self.deadLetter(ev, nil)
+	case LatePolicy_SPREAD:
+		spread := time.Duration(typeCfg.LateSpreadMSec) * time.Millisecond
+		spreadTime := time.Now()
+		if spread > 0 {
+			spreadTime = spreadTime.Add(time.Duration(rand.Int63n(int64(spread))))
+		}
+		ev.reschedule(spreadTime)
+		glog.Warningf("%s Scheduler is behind: %s, reschedule at %s", ev, late, ev.TriggerTime)
+		self.nbSpread.Next()
+		self.stored(ev, self.Store.UpdateForRetry(ev, nil))
+	default:
+		glog.Warningf("%s Scheduler is behind ev.TriggerTime: %s, triggering now", ev, late)
+		return true
+	}
+	return false
+}
+
+// deadLetter completes ev as ERROR, moving it to dead letters if the store keeps them.
+// Recurring events are not moved, their series goes on
+func (self *Scheduler) deadLetter(ev *Event, lastResult interface{}) {
+	store, ok := self.Store.(DeadLetterStoreInterface)
+	if !ok || ev.IsRecurring() {
+		self.complete(ev, EventStatus_ERROR, nil)
+		return
+	}
+	events := ev.followUps(EventStatus_ERROR, time.Now())
+	if len(events) > 0 {
+		glog.Infof("%s Create %d follow-up events", ev, len(events))
+	}
+	self.stored(ev, store.DeadLetter(ev, lastResult, events))
+}
+
+// complete finishes the current occurrence of ev along with creating its follow-up events,
+// recurring events are moved to their next occurrence unless cancelled or expired
+func (self *Scheduler) complete(ev *Event, status EventStatus, events []*Event) {
+	now := time.Now()
+	events = append(events, ev.followUps(status, now)...)
+	if len(events) > 0 {
+		glog.Infof("%s Create %d follow-up events", ev, len(events))
+	}
+
+	chainStore, chained := self.Store.(ChainStoreInterface)
+	if !chained && len(events) > 0 {
+		defer func() {
+			for _, followUp := range events {
+				self.Store.Save(followUp)
+			}
+		}()
+	}
+
+	// skipped occurrences of recurring events are not the end of the series
+	if ev.IsRecurring() && status != EventStatus_CANCEL && status != EventStatus_EXPIRED {
+		next, err := nextOccurrence(ev, self.missedPolicy, now)
+		if err != nil {
+			glog.Errorf("%s Can not get next occurrence: %s", ev, err)
+		} else if !next.IsZero() {
+			glog.Infof("%s Next occurrence: %s", ev, next)
+			ev.TriggerTime = next
+			ev.OccurrenceTime = time.Time{}
+			ev.Attempts = 0
+			ev.FirstAttempt = time.Time{}
+			ev.Occurrence++
+			if chained && len(events) > 0 {
+				self.stored(ev, chainStore.UpdateForNextAndSave(ev, events))
 			} else {
-				ev.TriggerTime = result.TriggerTime
+				self.stored(ev, self.Store.UpdateForNext(ev))
 			}
-			ev.Attempts++
-			self.Store.UpdateForRetry(ev, result.Data)
+			return
 		}
-	default:
-		self.Store.UpdateStatus(ev.Id, result.Status)
+	}
+
+	if chained && len(events) > 0 {
+		self.stored(ev, chainStore.UpdateStatusAndSave(ev, status, events))
+	} else if store, ok := self.Store.(OwnedStoreInterface); ok {
+		self.stored(ev, store.UpdateOwnedStatus(ev, status))
+	} else {
+		self.stored(ev, self.Store.UpdateStatus(ev.Id, status))
+	}
+}
+
+// stored records a write of ev which has failed, either because another consumer has taken ev since
+// or on an error of the store, in which case ev is triggered again once its ownership expires
+func (self *Scheduler) stored(ev *Event, err error) {
+	if err == ErrLostOwnership {
+		glog.Warningf("%s Lost ownership, left to its new owner", ev)
+		self.nbLostOwnership.Next()
+	} else if err != nil {
+		glog.Errorln(ev, "Store error:", err)
+		self.nbStoreError.Next()
 	}
 }
 
-func (self *Scheduler) getTrigger(triggerType string) TriggerInterface {
-	if trigger, ok := self.triggers[triggerType]; ok {
+// use appends middlewares, the first one wraps all the others
+func (self *Scheduler) use(middlewares ...TriggerMiddleware) {
+	self.triggerMutex.Lock()
+	defer self.triggerMutex.Unlock()
+
+	self.middlewares = append(self.middlewares, middlewares...)
+	wrap := func(trigger ContextTriggerInterface) ContextTriggerInterface {
+		for i := len(self.middlewares) - 1; i >= 0; i-- {
+			trigger = self.middlewares[i](trigger)
+		}
 		return trigger
 	}
-	return noTrigger
+	self.wrapped = make(map[string]ContextTriggerInterface)
+	for triggerType, trigger := range self.triggers {
+		self.wrapped[triggerType] = wrap(trigger)
+	}
+	self.noTrigger = wrap(noTrigger)
+}
+
+func (self *Scheduler) getTrigger(triggerType string) ContextTriggerInterface {
+	self.triggerMutex.RLock()
+	defer self.triggerMutex.RUnlock()
+
+	if trigger, ok := self.wrapped[triggerType]; ok {
+		return trigger
+	}
+	return self.noTrigger
+}
+
+func (self *Scheduler) getTriggerTypeConfig(triggerType string) *TriggerTypeConfig {
+	if typeCfg, ok := self.triggerTypes[triggerType]; ok {
+		return typeCfg
+	}
+	return self.triggerTypeDefault
+}
+
+// getBackoffPolicy returns the policy named by ev.BackoffPolicy, or the one of its trigger type
+func (self *Scheduler) getBackoffPolicy(ev *Event, typeCfg *TriggerTypeConfig) BackoffPolicy {
+	self.backoffMutex.RLock()
+	defer self.backoffMutex.RUnlock()
+
+	if ev.BackoffPolicy != "" {
+		if policy, ok := self.namedBackoffPolicies[ev.BackoffPolicy]; ok {
+			return policy
+		}
+		glog.Warningf("%s Unknown backoff policy: %s", ev, ev.BackoffPolicy)
+	}
+	if policy, ok := self.backoffPolicies[ev.TriggerType]; ok {
+		return policy
+	}
+	return typeCfg.backoffPolicy()
 }
 
 func (self *Scheduler) GetStat(reset bool) map[string]interface{} {
 	self.eventMutex.RLock()
-	nbEvents := self.events.Len()
-	nbCapacity := self.events.maxSize - nbEvents
+	nbEvents := self.events.len()
+	nbCapacity := self.events.capacity() - nbEvents
 	self.eventMutex.RUnlock()
 
 	stat := map[string]interface{}{
@@ -182,13 +844,48 @@ func (self *Scheduler) GetStat(reset bool) map[string]interface{} {
 		"nbTriggered": self.nbTriggered.Get(),
 		"nbGiveup":    self.nbGiveup.Get(),
 		"nbRecovered": self.nbRecovered.Get(),
+		"nbSkipped":   self.nbSkipped.Get(),
+		"nbExpired":   self.nbExpired.Get(),
+
+		"nbSpread":     self.nbSpread.Get(),
+		"nbDeadLetter": self.nbDeadLetter.Get(),
+
+		"nbTimeout":     self.nbTimeout.Get(),
+		"nbInterrupted": self.nbInterrupted.Get(),
+		"nbThrottled":   self.nbThrottled.Get(),
+
+		"nbPending":    self.nbPending.Get(),
+		"nbAcked":      self.nbAcked.Get(),
+		"nbAckTimeout": self.nbAckTimeout.Get(),
+		"nbRenewed":    self.nbRenewed.Get(),
+		"nbReleased":   self.nbReleased.Get(),
+		"nbEvicted":    self.nbEvicted.Get(),
+
+		"nbLostOwnership": self.nbLostOwnership.Get(),
+		"nbStoreError":    self.nbStoreError.Get(),
 	}
+	self.pool.getStat(stat, reset)
 
 	if reset {
 		self.nbDelayed.Reset()
 		self.nbTriggered.Reset()
 		self.nbGiveup.Reset()
 		self.nbRecovered.Reset()
+		self.nbSkipped.Reset()
+		self.nbExpired.Reset()
+		self.nbSpread.Reset()
+		self.nbDeadLetter.Reset()
+		self.nbTimeout.Reset()
+		self.nbInterrupted.Reset()
+		self.nbThrottled.Reset()
+		self.nbPending.Reset()
+		self.nbAcked.Reset()
+		self.nbAckTimeout.Reset()
+		self.nbLostOwnership.Reset()
+		self.nbStoreError.Reset()
+		self.nbRenewed.Reset()
+		self.nbReleased.Reset()
+		self.nbEvicted.Reset()
 	}
 
 	return stat
@@ -204,4 +901,4 @@ func (self *NoTrigger) Trigger(ev *Event) *TriggerResult {
 	}
 }
 
-var noTrigger = &NoTrigger{}
+var noTrigger = WithContext(&NoTrigger{})
@@ -4,7 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/golang/glog"
 	"github.com/satori/go.uuid"
 	"strconv"
@@ -14,29 +14,85 @@ import (
 
 const (
 	SQL_TMPL_CREATE_DATABASE = `CREATE DATABASE IF NOT EXISTS %s`
-	SQL_TMPL_CREATE_TABLE    = `CREATE TABLE IF NOT EXISTS %s (
+	SQL_TMPL_CREATE_TABLE    = `CREATE TABLE IF NOT EXISTS %[1]s (
  id VARCHAR(128) NOT NULL,
  trigger_type VARCHAR(64),
- trigger_time DATETIME%s NOT NULL,
+ trigger_time DATETIME%[2]s NOT NULL,
  retry_attempts INT DEFAULT 0,
  data TEXT,
  status INT,
+ group_key VARCHAR(128) NOT NULL DEFAULT '',
+ parent_id VARCHAR(128) NOT NULL DEFAULT '',
+ deadline DATETIME%[2]s DEFAULT NULL,
+ cron_spec VARCHAR(128) NOT NULL DEFAULT '',
+ repeat_interval_msec BIGINT NOT NULL DEFAULT 0,
+ max_occurrences INT NOT NULL DEFAULT 0,
+ end_time DATETIME%[2]s DEFAULT NULL,
+ occurrence INT NOT NULL DEFAULT 1,
+ occurrence_time DATETIME%[2]s DEFAULT NULL,
+ follow_ups TEXT,
+ first_attempt DATETIME%[2]s DEFAULT NULL,
+ backoff_policy VARCHAR(64) NOT NULL DEFAULT '',
  owner VARCHAR(64) NOT NULL DEFAULT '',
- owner_lock_time DATETIME%s DEFAULT NULL,
+ owner_lock_time DATETIME%[2]s DEFAULT NULL,
  owner_seq BIGINT NOT NULL DEFAULT 0,
- time_created DATETIME%s,
- PRIMARY KEY(id))`
+ partition_id INT UNSIGNED NOT NULL DEFAULT 0,
+ time_created DATETIME%[2]s,
+ PRIMARY KEY(id),
+ KEY idx_group_key(group_key),
+ KEY idx_parent_id(parent_id),
+ KEY idx_status_trigger_type_time(status, trigger_type, trigger_time),
+ KEY idx_owner_trigger_time(owner, trigger_time))`
+	// live consumers dividing partitions
+	SQL_TMPL_CREATE_CONSUMER_TABLE = `CREATE TABLE IF NOT EXISTS %[1]s (
+ owner VARCHAR(64) NOT NULL,
+ heartbeat_time DATETIME NOT NULL,
+ PRIMARY KEY(owner))`
+	SQL_TMPL_CANCEL_WHERE    = `UPDATE %s SET status=? WHERE status=? AND %s LIMIT %d`
+	SQL_TMPL_SELECT_WHERE    = `SELECT ` + SQL_EVENT_COLUMNS + ` FROM %s WHERE status=? AND %s ORDER BY trigger_time`
+	SQL_TMPL_COUNT_WHERE     = `SELECT COUNT(*) FROM %s WHERE status=? AND %s`
+	SQL_TMPL_SELECT_CHILDREN = `SELECT id, status FROM %s WHERE parent_id IN (%s)`
+	SQL_TMPL_CANCEL_IDS      = `UPDATE %s SET status=? WHERE status=? AND id IN (%s)`
+	SQL_TMPL_SELECT_STATUS   = `SELECT id FROM %s WHERE status=? AND id IN (%s)`
+
+	// fences writes of consumers, they fail if the event has been claimed by another consumer since,
+	// writes of events without owner are not fenced
+	SQL_OWNED_CONDITION = ` AND (?='' OR (owner=? AND owner_seq=?))`
+
+	// columns read by scanEvents
+	SQL_EVENT_COLUMNS = `id, trigger_type, trigger_time, retry_attempts, data, status, group_key, parent_id,
+ deadline, cron_spec, repeat_interval_msec, max_occurrences, end_time, occurrence, occurrence_time, follow_ups, first_attempt,
+ backoff_policy`
 )
 
 var (
 	SQL_SAVE_EVENT             string
+	SQL_SAVE_OWNED_EVENT       string
+	SQL_SAVE_JOB               string
+	SQL_SELECT_EVENT           string
+	SQL_SELECT_CHILDREN        string
 	SQL_DELETE_EVENT           string
+	SQL_DELETE_OWNED_EVENT     string
+	SQL_DELETE_CANCELLED_EVENT string
 	SQL_UPDATE_EVENT_STATUS    string
 	SQL_UPDATE_EVENT_FOR_RETRY string
+	SQL_UPDATE_EVENT_FOR_NEXT  string
+	SQL_UPDATE_EVENT_FOR_ACK   string
+	SQL_ACK_EVENT              string
+
+	SQL_RESET_DELAYED_EVENTS   string
+	SQL_DECLARE_OWNERSHIP      string
+	SQL_SELECT_EVENTS          string
+	SQL_NEXT_TRIGGER_TIME      string
+	SQL_TMPL_RENEW_OWNERSHIP   string
+	SQL_TMPL_RELEASE_OWNERSHIP string
 
-	SQL_RESET_DELAYED_EVENTS string
-	SQL_DECLARE_OWNERSHIP    string
-	SQL_SELECT_EVENTS        string
+	SQL_TMPL_DECLARE_PARTITION_OWNERSHIP string
+	SQL_TMPL_NEXT_PARTITION_TRIGGER_TIME string
+	SQL_HEARTBEAT_CONSUMER               string
+	SQL_DELETE_DEAD_CONSUMERS            string
+	SQL_SELECT_CONSUMERS                 string
+	SQL_DELETE_CONSUMER                  string
 )
 
 func openMySQL(cfg *MySQLConfig) (*sql.DB, error) {
@@ -45,7 +101,7 @@ func openMySQL(cfg *MySQLConfig) (*sql.DB, error) {
 		suf = "(6)"
 	}
 	sqlCreateDb := fmt.Sprintf(SQL_TMPL_CREATE_DATABASE, cfg.DbName)
-	sqlCreateTable := fmt.Sprintf(SQL_TMPL_CREATE_TABLE, cfg.TableName, suf, suf, suf)
+	sqlCreateTable := fmt.Sprintf(SQL_TMPL_CREATE_TABLE, cfg.TableName, suf)
 
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/",
 		cfg.User, cfg.Pass, cfg.Host, cfg.Port)
@@ -68,9 +124,31 @@ func openMySQL(cfg *MySQLConfig) (*sql.DB, error) {
 	if _, err = db.Exec(sqlCreateTable); err != nil {
 		return nil, err
 	}
+	if err = migrateTable(db, cfg.TableName, suf, SQL_EVENT_ADDED_COLUMNS, SQL_EVENT_ADDED_INDEXES,
+		SQL_TMPL_EVENT_FILLED_COLUMNS); err != nil {
+		return nil, err
+	}
+	if _, err = db.Exec(fmt.Sprintf(SQL_TMPL_CREATE_DEAD_LETTER_TABLE, cfg.DeadLetterTableName, suf)); err != nil {
+		return nil, err
+	}
+	if err = migrateTable(db, cfg.DeadLetterTableName, suf, SQL_DEAD_LETTER_ADDED_COLUMNS, nil, nil); err != nil {
+		return nil, err
+	}
+	if cfg.Partitions > 0 {
+		if _, err = db.Exec(fmt.Sprintf(SQL_TMPL_CREATE_CONSUMER_TABLE, cfg.ConsumerTableName)); err != nil {
+			return nil, err
+		}
+	}
 	return db, nil
 }
 
+// owner of acknowledged events being completed, not claimed by consumers
+const ACK_OWNER = "ack"
+
+// returned by writes of an event which has been claimed by another consumer since it was taken,
+// the event is left to its new owner
+var ErrLostOwnership = fmt.Errorf("Lost ownership of event")
+
 type MySQLStore struct {
 	cfg        *MySQLConfig
 	timeWindow time.Duration
@@ -82,28 +160,101 @@ type MySQLStore struct {
 	nbCancel   Seq32
 	nbComplete Seq32
 	nbRetry    Seq32
+	nbNext     Seq32
 	nbReset    Seq32
+
+	nbDeadLetter Seq32
+	nbRedrive    Seq32
+	nbPending    Seq32
+	nbAck        Seq32
+	nbRenew      Seq32
+	nbRelease    Seq32
+
+	nbLostOwnership Seq32
 }
 
 func NewMySQLStore(cfg *Config) *MySQLStore {
 	SQL_SAVE_EVENT = fmt.Sprintf(`INSERT INTO %s
- (id, trigger_type, trigger_time, data, status, time_created)
- VALUES (?, ?, ?, ?, ?, NOW())`, cfg.TableName)
+ (id, trigger_type, trigger_time, data, status, group_key, parent_id,
+ deadline, cron_spec, repeat_interval_msec, max_occurrences, end_time, occurrence, follow_ups, backoff_policy,
+ partition_id, time_created)
+ VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NOW())`, cfg.TableName)
+	// claimed at once by a consumer of the creating instance
+	SQL_SAVE_OWNED_EVENT = fmt.Sprintf(`INSERT INTO %s
+ (id, trigger_type, trigger_time, data, status, group_key, parent_id,
+ deadline, cron_spec, repeat_interval_msec, max_occurrences, end_time, occurrence, follow_ups, backoff_policy,
+ partition_id, owner, owner_seq, owner_lock_time, time_created)
+ VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NOW(), NOW())`, cfg.TableName)
+	// keep the scheduled occurrence unless cron spec has been changed or the job has been cancelled,
+	// in which case it is rescheduled and taken from its owner. Columns are assigned in order,
+	// cron_spec and status are compared before being updated
+	jobReset := fmt.Sprintf(`cron_spec<>VALUES(cron_spec) OR status=%d`, EventStatus_CANCEL)
+	SQL_SAVE_JOB = fmt.Sprintf(`INSERT INTO %[1]s
+ (id, trigger_type, trigger_time, data, status, cron_spec, partition_id, time_created)
+ VALUES (?, ?, ?, ?, ?, ?, ?, NOW())
+ ON DUPLICATE KEY UPDATE
+ trigger_time=IF(%[2]s, VALUES(trigger_time), trigger_time),
+ retry_attempts=IF(%[2]s, 0, retry_attempts),
+ first_attempt=IF(%[2]s, NULL, first_attempt),
+ occurrence_time=IF(%[2]s, NULL, occurrence_time),
+ owner=IF(%[2]s, '', owner),
+ owner_seq=IF(%[2]s, 0, owner_seq),
+ owner_lock_time=IF(%[2]s, NULL, owner_lock_time),
+ status=IF(status=%[3]d, VALUES(status), status),
+ trigger_type=VALUES(trigger_type), data=VALUES(data), cron_spec=VALUES(cron_spec)`,
+		cfg.TableName, jobReset, EventStatus_CANCEL)
+	SQL_SELECT_EVENT = fmt.Sprintf(`SELECT `+SQL_EVENT_COLUMNS+` FROM %s WHERE id=?`, cfg.TableName)
+	SQL_SELECT_CHILDREN = fmt.Sprintf(`SELECT id FROM %s WHERE parent_id=?`, cfg.TableName)
 	SQL_DELETE_EVENT = fmt.Sprintf(`DELETE FROM %s WHERE id=?`, cfg.TableName)
+	SQL_DELETE_OWNED_EVENT = SQL_DELETE_EVENT + SQL_OWNED_CONDITION
+	// left if reactivated since cancelled, e.g. a job registered again
+	SQL_DELETE_CANCELLED_EVENT = fmt.Sprintf(`DELETE FROM %s WHERE id=? AND status=%d`, cfg.TableName, EventStatus_CANCEL)
 	SQL_UPDATE_EVENT_STATUS = fmt.Sprintf(`UPDATE %s SET status=? WHERE id=?`, cfg.TableName)
 	SQL_UPDATE_EVENT_FOR_RETRY = fmt.Sprintf(`UPDATE %s SET
  owner='', owner_lock_time=NULL, owner_seq=0,
- trigger_time=?, retry_attempts=? WHERE id=?`, cfg.TableName)
+ trigger_time=?, retry_attempts=?, first_attempt=?, occurrence_time=? WHERE id=?`+SQL_OWNED_CONDITION, cfg.TableName)
+	SQL_UPDATE_EVENT_FOR_NEXT = fmt.Sprintf(`UPDATE %s SET
+ owner='', owner_lock_time=NULL, owner_seq=0,
+ trigger_time=?, retry_attempts=0, first_attempt=NULL, occurrence=?, occurrence_time=NULL WHERE id=?`+SQL_OWNED_CONDITION, cfg.TableName)
+
+	SQL_UPDATE_EVENT_FOR_ACK = fmt.Sprintf(`UPDATE %s SET
+ owner='', owner_lock_time=NULL, owner_seq=0,
+ status=%d, trigger_time=?, occurrence_time=? WHERE id=? AND status=%d`+SQL_OWNED_CONDITION, cfg.TableName, EventStatus_PENDING, EventStatus_DEFAULT)
+	// owned by ACK_OWNER until completed, reset after ConsumerLockTimeoutSec if not completed
+	SQL_ACK_EVENT = fmt.Sprintf(`UPDATE %s SET owner='%s', owner_lock_time=NOW(), owner_seq=0, status=%d
+ WHERE id=? AND status=%d`, cfg.TableName, ACK_OWNER, EventStatus_DEFAULT, EventStatus_PENDING)
+
+	initDeadLetterSQL(cfg)
 
 	// used by consumer
 	SQL_RESET_DELAYED_EVENTS = fmt.Sprintf(`UPDATE %s SET owner='', owner_lock_time=NULL WHERE
    owner != '' AND owner_lock_time < SUBDATE( NOW(), INTERVAL %d SECOND )`,
 		cfg.TableName, cfg.ConsumerLockTimeoutSec)
 
+	// ids are prefixed by the first trigger time, trigger_time is checked as well for
+	// retried and recurring events which are rescheduled later than their ids
 	SQL_DECLARE_OWNERSHIP = fmt.Sprintf(`UPDATE %s SET owner=?, owner_lock_time=NOW(), owner_seq=? WHERE
-   id < ? AND owner = '' LIMIT %d`, cfg.TableName, cfg.ConsumerSelectLimit)
-	SQL_SELECT_EVENTS = fmt.Sprintf(`SELECT id, trigger_type, trigger_time, retry_attempts, data, status
+   id < ? AND trigger_time < ? AND owner = '' LIMIT ?`, cfg.TableName)
+	SQL_SELECT_EVENTS = fmt.Sprintf(`SELECT `+SQL_EVENT_COLUMNS+`
  FROM %s WHERE id < ? AND owner=? AND (owner_seq=? or status=%d)`, cfg.TableName, EventStatus_CANCEL)
+	SQL_NEXT_TRIGGER_TIME = fmt.Sprintf(`SELECT MIN(trigger_time) FROM %s WHERE owner=''`, cfg.TableName)
+	SQL_TMPL_RENEW_OWNERSHIP = fmt.Sprintf(`UPDATE %s SET owner_lock_time=NOW() WHERE owner=? AND id IN (%%s)`,
+		cfg.TableName)
+	SQL_TMPL_RELEASE_OWNERSHIP = fmt.Sprintf(`UPDATE %s SET owner='', owner_lock_time=NULL, owner_seq=0
+ WHERE owner=? AND id IN (%%s)`, cfg.TableName)
+
+	// used by consumers dividing partitions, every event belongs to one of the current partitions
+	// whatever the number of partitions it has been created with
+	SQL_TMPL_DECLARE_PARTITION_OWNERSHIP = fmt.Sprintf(`UPDATE %s SET owner=?, owner_lock_time=NOW(), owner_seq=? WHERE
+   id < ? AND trigger_time < ? AND owner = '' AND MOD(partition_id, %d) IN (%%s) LIMIT ?`, cfg.TableName, cfg.Partitions)
+	SQL_TMPL_NEXT_PARTITION_TRIGGER_TIME = fmt.Sprintf(`SELECT MIN(trigger_time) FROM %s
+ WHERE owner='' AND MOD(partition_id, %d) IN (%%s)`, cfg.TableName, cfg.Partitions)
+	SQL_HEARTBEAT_CONSUMER = fmt.Sprintf(`INSERT INTO %s (owner, heartbeat_time) VALUES (?, NOW())
+ ON DUPLICATE KEY UPDATE heartbeat_time=NOW()`, cfg.ConsumerTableName)
+	SQL_DELETE_DEAD_CONSUMERS = fmt.Sprintf(`DELETE FROM %s WHERE
+ heartbeat_time < SUBDATE( NOW(), INTERVAL %d SECOND )`, cfg.ConsumerTableName, 3*cfg.ConsumerHeartbeatSec)
+	SQL_SELECT_CONSUMERS = fmt.Sprintf(`SELECT owner FROM %s ORDER BY owner`, cfg.ConsumerTableName)
+	SQL_DELETE_CONSUMER = fmt.Sprintf(`DELETE FROM %s WHERE owner=?`, cfg.ConsumerTableName)
 
 	return &MySQLStore{
 		cfg:        &cfg.MySQLConfig,
@@ -133,27 +284,92 @@ func (self *MySQLStore) Close() {
 	}
 }
 
+// either *sql.DB or *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
 func (self *MySQLStore) Save(ev *Event) string {
+	if err := self.saveEvent(self.db, ev); err != nil {
+		glog.Errorln("Save:", err)
+		self.nbError.Next()
+		return ""
+	}
+	return ev.Id
+}
+
+func (self *MySQLStore) saveEvent(db execer, ev *Event) error {
+	return self.insertEvent(db, SQL_SAVE_EVENT, ev)
+}
+
+// insertEvent saves ev with a new id by query, whose placeholders are followed by extra
+func (self *MySQLStore) insertEvent(db execer, query string, ev *Event, extra ...interface{}) error {
 	ev.Id = fmt.Sprintf("%d_%s", ev.TriggerTime.Unix(), uuid.NewV1().String())
 	glog.Infoln("Save", ev)
 	self.nbSave.Next()
 
+	args := []interface{}{
+		ev.Id,
+		ev.TriggerType,
+		ev.TriggerTime,
+		encodeData(ev.Data),
+		ev.Status,
+		ev.GroupKey,
+		ev.ParentId,
+		nullTime(ev.Deadline),
+		ev.CronSpec,
+		int64(ev.Interval / time.Millisecond),
+		ev.MaxOccurrences,
+		nullTime(ev.EndTime),
+		ev.Occurrence,
+		encodeFollowUps(ev),
+		ev.BackoffPolicy,
+		partitionKey(ev.Id),
+	}
+	_, err := db.Exec(query, append(args, extra...)...)
+	return err
+}
+
+// saveOwned saves ev claimed by ownerId with seq, returns it as it would be read by the consumer
+func (self *MySQLStore) saveOwned(ev *Event, ownerId string, seq int32) (*Event, error) {
+	if err := self.insertEvent(self.db, SQL_SAVE_OWNED_EVENT, ev, ownerId, seq); err != nil {
+		glog.Errorln("saveOwned:", err)
+		self.nbError.Next()
+		ev.Id = ""
+		return nil, err
+	}
+	claimed := *ev
+	claimed.Data = decodeData(encodeData(ev.Data))
+	if strFollowUps, ok := encodeFollowUps(ev).(string); ok {
+		decodeFollowUps(&claimed, strFollowUps)
+	}
+	claimed.Owner = ownerId
+	claimed.OwnerSeq = seq
+	return &claimed, nil
+}
+
+func (self *MySQLStore) SaveJob(ev *Event) error {
+	glog.Infoln("SaveJob", ev, ev.CronSpec)
+	self.nbSave.Next()
+
 	jsonBytes, _ := Encoder.Marshal(ev.Data)
 	evData := strings.TrimSpace(string(jsonBytes))
 
-	_, err := self.db.Exec(SQL_SAVE_EVENT,
+	_, err := self.db.Exec(SQL_SAVE_JOB,
 		ev.Id,
 		ev.TriggerType,
 		ev.TriggerTime,
 		evData,
 		ev.Status,
+		ev.CronSpec,
+		partitionKey(ev.Id),
 	)
 	if err != nil {
-		glog.Errorln("Save:", err)
+		glog.Errorln("SaveJob:", err)
 		self.nbError.Next()
-		return ""
+		return err
 	}
-	return ev.Id
+	return nil
 }
 
 func (self *MySQLStore) Cancel(evId string) error {
@@ -163,27 +379,363 @@ func (self *MySQLStore) Cancel(evId string) error {
 	return self.updateEventStatus(evId, EventStatus_CANCEL)
 }
 
+// CancelWhere cancels pending events matching filter, BulkChunkSize rows per statement
+// so that a huge cancellation does not hold row locks for long.
+func (self *MySQLStore) CancelWhere(filter *EventFilter) (int, error) {
+	glog.Infoln("CancelWhere", filter)
+	self.nbCancel.Next()
+
+	if filter == nil || filter.IsEmpty() {
+		return 0, fmt.Errorf("Empty filter")
+	}
+	cond, condArgs := filterCondition(filter)
+	query := fmt.Sprintf(SQL_TMPL_CANCEL_WHERE, self.cfg.TableName, cond, self.cfg.BulkChunkSize)
+	args := append([]interface{}{EventStatus_CANCEL, EventStatus_DEFAULT}, condArgs...)
+
+	total := 0
+	for {
+		res, err := self.db.Exec(query, args...)
+		if err != nil {
+			glog.Errorln("CancelWhere:", err, total)
+			self.nbError.Next()
+			return total, err
+		}
+		rowsAffected, _ := res.RowsAffected()
+		total += int(rowsAffected)
+		if rowsAffected < int64(self.cfg.BulkChunkSize) {
+			break
+		}
+	}
+	glog.Infoln("CancelWhere cancelled", total)
+	return total, nil
+}
+
+// ListWhere returns pending events matching filter ordered by trigger time, limit <= 0 means no limit
+func (self *MySQLStore) ListWhere(filter *EventFilter, limit int) ([]*Event, error) {
+	if filter == nil || filter.IsEmpty() {
+		return nil, fmt.Errorf("Empty filter")
+	}
+	cond, condArgs := filterCondition(filter)
+	query := fmt.Sprintf(SQL_TMPL_SELECT_WHERE, self.cfg.TableName, cond)
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	args := append([]interface{}{EventStatus_DEFAULT}, condArgs...)
+
+	rows, err := self.db.Query(query, args...)
+	if err != nil {
+		glog.Errorln("ListWhere:", err)
+		self.nbError.Next()
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanEvents(rows)
+}
+
+func (self *MySQLStore) CountWhere(filter *EventFilter) (int, error) {
+	if filter == nil || filter.IsEmpty() {
+		return 0, fmt.Errorf("Empty filter")
+	}
+	cond, condArgs := filterCondition(filter)
+	query := fmt.Sprintf(SQL_TMPL_COUNT_WHERE, self.cfg.TableName, cond)
+	args := append([]interface{}{EventStatus_DEFAULT}, condArgs...)
+
+	count := 0
+	if err := self.db.QueryRow(query, args...).Scan(&count); err != nil {
+		glog.Errorln("CountWhere:", err)
+		self.nbError.Next()
+		return 0, err
+	}
+	return count, nil
+}
+
+func (self *MySQLStore) Get(evId string) (*Event, error) {
+	rows, err := self.db.Query(SQL_SELECT_EVENT, evId)
+	if err != nil {
+		glog.Errorln("Get:", err, evId)
+		self.nbError.Next()
+		return nil, err
+	}
+	defer rows.Close()
+	events, err := scanEvents(rows)
+	if err != nil || len(events) == 0 {
+		return nil, err
+	}
+	ev := events[0]
+
+	childRows, err := self.db.Query(SQL_SELECT_CHILDREN, evId)
+	if err != nil {
+		glog.Errorln("Get children:", err, evId)
+		self.nbError.Next()
+		return nil, err
+	}
+	defer childRows.Close()
+	ev.Children = make([]string, 0)
+	for childRows.Next() {
+		var childId string
+		if err := childRows.Scan(&childId); err != nil {
+			return nil, err
+		}
+		ev.Children = append(ev.Children, childId)
+	}
+	return ev, childRows.Err()
+}
+
+func (self *MySQLStore) CancelCascade(evId string) ([]string, error) {
+	glog.Infoln("CancelCascade", evId)
+	self.nbCancel.Next()
+
+	tx, err := self.db.Begin()
+	if err != nil {
+		glog.Errorln("Begin:", err)
+		self.nbError.Next()
+		return nil, err
+	}
+	cancelled, err := self.cancelCascade(tx, evId)
+	if err == nil {
+		err = tx.Commit()
+	} else {
+		tx.Rollback()
+	}
+	if err != nil {
+		glog.Errorln("CancelCascade:", err, evId)
+		self.nbError.Next()
+		return nil, err
+	}
+	glog.Infoln("CancelCascade cancelled", evId, len(cancelled))
+	return cancelled, nil
+}
+
+// walks down the tree level by level, locking descendants until the transaction ends
+func (self *MySQLStore) cancelCascade(tx *sql.Tx, evId string) ([]string, error) {
+	// the event itself is cancelled whatever its status as by Cancel, its pending descendants only
+	res, err := tx.Exec(SQL_UPDATE_EVENT_STATUS, EventStatus_CANCEL, evId)
+	if err != nil {
+		return nil, err
+	}
+	cancelled := []string{}
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected > 0 {
+		cancelled = append(cancelled, evId)
+	}
+
+	// locked pending descendants, descendants of completed ones included
+	visited := map[string]bool{evId: true}
+	ids := []string{}
+	for level := []string{evId}; len(level) > 0; {
+		query := fmt.Sprintf(SQL_TMPL_SELECT_CHILDREN+" FOR UPDATE", self.cfg.TableName, placeholders(len(level)))
+		rows, err := tx.Query(query, stringArgs(level)...)
+		if err != nil {
+			return nil, err
+		}
+		level = make([]string, 0)
+		for rows.Next() {
+			var childId string
+			var status EventStatus
+			if err := rows.Scan(&childId, &status); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if visited[childId] {
+				continue
+			}
+			visited[childId] = true
+			level = append(level, childId)
+			if status == EventStatus_DEFAULT {
+				ids = append(ids, childId)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for begin := 0; begin < len(ids); begin += self.cfg.BulkChunkSize {
+		end := begin + self.cfg.BulkChunkSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		chunk := ids[begin:end]
+		query := fmt.Sprintf(SQL_TMPL_CANCEL_IDS, self.cfg.TableName, placeholders(len(chunk)))
+		args := append([]interface{}{EventStatus_CANCEL, EventStatus_DEFAULT}, stringArgs(chunk)...)
+		if _, err := tx.Exec(query, args...); err != nil {
+			return nil, err
+		}
+		cancelled = append(cancelled, chunk...)
+	}
+	return cancelled, nil
+}
+
+// UpdateStatus completes an event, a cancelled one only if it is still cancelled
 func (self *MySQLStore) UpdateStatus(evId string, status EventStatus) error {
 	glog.Infoln("UpdateStatus", evId, status)
 	self.nbComplete.Next()
-	return self.deleteEvent(evId)
+	if status == EventStatus_CANCEL {
+		return self.deleteEvent(SQL_DELETE_CANCELLED_EVENT, evId)
+	}
+	return self.deleteEvent(SQL_DELETE_EVENT, evId)
+}
+
+func (self *MySQLStore) UpdateOwnedStatus(ev *Event, status EventStatus) error {
+	glog.Infoln("UpdateOwnedStatus", ev.Id, status)
+	self.nbComplete.Next()
+
+	if err := self.ownedExec(self.db, ev, SQL_DELETE_OWNED_EVENT, ev.Id); err != nil {
+		glog.Errorln("UpdateOwnedStatus:", err, ev.Id)
+		if err != ErrLostOwnership {
+			self.nbError.Next()
+		}
+		return err
+	}
+	return nil
 }
 
 func (self *MySQLStore) UpdateForRetry(ev *Event, retryParam interface{}) error {
 	glog.Infoln("UpdateForRetry", ev.Id, ev.TriggerTime, ev.Attempts)
 	self.nbRetry.Next()
 
-	_, err := self.db.Exec(SQL_UPDATE_EVENT_FOR_RETRY, ev.TriggerTime, ev.Attempts, ev.Id)
+	err := self.ownedExec(self.db, ev, SQL_UPDATE_EVENT_FOR_RETRY,
+		ev.TriggerTime, ev.Attempts, nullTime(ev.FirstAttempt), nullTime(ev.OccurrenceTime), ev.Id)
 	if err != nil {
 		glog.Errorln("UpdateForRetry:", err, ev.Id)
+		if err != ErrLostOwnership {
+			self.nbError.Next()
+		}
+		return err
+	}
+	return nil
+}
+
+func (self *MySQLStore) UpdateForNext(ev *Event) error {
+	glog.Infoln("UpdateForNext", ev.Id, ev.TriggerTime, ev.Occurrence)
+	self.nbNext.Next()
+
+	err := self.ownedExec(self.db, ev, SQL_UPDATE_EVENT_FOR_NEXT, ev.TriggerTime, ev.Occurrence, ev.Id)
+	if err != nil {
+		glog.Errorln("UpdateForNext:", err, ev.Id)
+		if err != ErrLostOwnership {
+			self.nbError.Next()
+		}
+		return err
+	}
+	return nil
+}
+
+func (self *MySQLStore) UpdateForAck(ev *Event, retryParam interface{}) error {
+	glog.Infoln("UpdateForAck", ev.Id, ev.TriggerTime)
+	self.nbPending.Next()
+
+	err := self.ownedExec(self.db, ev, SQL_UPDATE_EVENT_FOR_ACK, ev.TriggerTime, nullTime(ev.OccurrenceTime), ev.Id)
+	if err != nil {
+		glog.Errorln("UpdateForAck:", err, ev.Id)
+		if err != ErrLostOwnership {
+			self.nbError.Next()
+		}
+		return err
+	}
+	return nil
+}
+
+func (self *MySQLStore) Ack(evId string) (*Event, error) {
+	glog.Infoln("Ack", evId)
+	self.nbAck.Next()
+
+	res, err := self.db.Exec(SQL_ACK_EVENT, evId)
+	if err != nil {
+		glog.Errorln("Ack:", err, evId)
+		self.nbError.Next()
+		return nil, err
+	}
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		return nil, nil
+	}
+
+	rows, err := self.db.Query(SQL_SELECT_EVENT, evId)
+	if err != nil {
+		glog.Errorln("Ack:", err, evId)
+		self.nbError.Next()
+		return nil, err
+	}
+	defer rows.Close()
+	events, err := scanEvents(rows)
+	if err != nil || len(events) == 0 {
+		return nil, err
+	}
+	events[0].Owner = ACK_OWNER
+	return events[0], nil
+}
+
+func (self *MySQLStore) UpdateStatusAndSave(ev *Event, status EventStatus, events []*Event) error {
+	glog.Infoln("UpdateStatusAndSave", ev.Id, status, len(events))
+	self.nbComplete.Next()
+
+	return self.saveInTx(events, func(tx *sql.Tx) error {
+		return self.ownedExec(tx, ev, SQL_DELETE_OWNED_EVENT, ev.Id)
+	})
+}
+
+func (self *MySQLStore) UpdateForNextAndSave(ev *Event, events []*Event) error {
+	glog.Infoln("UpdateForNextAndSave", ev.Id, ev.TriggerTime, ev.Occurrence, len(events))
+	self.nbNext.Next()
+
+	return self.saveInTx(events, func(tx *sql.Tx) error {
+		return self.ownedExec(tx, ev, SQL_UPDATE_EVENT_FOR_NEXT, ev.TriggerTime, ev.Occurrence, ev.Id)
+	})
+}
+
+// saves events in the transaction running update
+func (self *MySQLStore) saveInTx(events []*Event, update func(tx *sql.Tx) error) error {
+	tx, err := self.db.Begin()
+	if err != nil {
+		glog.Errorln("Begin:", err)
 		self.nbError.Next()
 		return err
 	}
+
+	if err = update(tx); err == nil {
+		for _, ev := range events {
+			if err = self.saveEvent(tx, ev); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	} else {
+		tx.Rollback()
+	}
+
+	if err != nil {
+		glog.Errorln("saveInTx:", err)
+		if err != ErrLostOwnership {
+			self.nbError.Next()
+		}
+		for _, ev := range events {
+			ev.Id = ""
+		}
+		return err
+	}
+	return nil
+}
+
+// ownedExec runs query fenced by SQL_OWNED_CONDITION, ErrLostOwnership if ev is not owned anymore
+// by ev.Owner since ev.OwnerSeq
+func (self *MySQLStore) ownedExec(db execer, ev *Event, query string, args ...interface{}) error {
+	res, err := db.Exec(query, append(args, ev.Owner, ev.Owner, ev.OwnerSeq)...)
+	if err != nil {
+		return err
+	}
+	// fenced writes always change the row, unfenced ones may leave it as it was
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 && ev.Owner != "" {
+		self.nbLostOwnership.Next()
+		return ErrLostOwnership
+	}
 	return nil
 }
 
-func (self *MySQLStore) deleteEvent(id string) error {
-	if _, err := self.db.Exec(SQL_DELETE_EVENT, id); err != nil {
+func (self *MySQLStore) deleteEvent(query string, id string) error {
+	if _, err := self.db.Exec(query, id); err != nil {
 		glog.Errorln("deleteEvent:", err, id)
 		self.nbError.Next()
 		return err
@@ -206,6 +758,28 @@ func (self *MySQLStore) updateEventStatus(id string, status EventStatus) error {
 	return nil
 }
 
+func filterCondition(filter *EventFilter) (string, []interface{}) {
+	conds := make([]string, 0)
+	args := make([]interface{}, 0)
+	if filter.TriggerType != "" {
+		conds = append(conds, "trigger_type=?")
+		args = append(args, filter.TriggerType)
+	}
+	if filter.GroupKey != "" {
+		conds = append(conds, "group_key=?")
+		args = append(args, filter.GroupKey)
+	}
+	if !filter.From.IsZero() {
+		conds = append(conds, "trigger_time>=?")
+		args = append(args, filter.From)
+	}
+	if !filter.To.IsZero() {
+		conds = append(conds, "trigger_time<?")
+		args = append(args, filter.To)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
 func (self *MySQLStore) resetDelayedEvents(ownerId string) error {
 	if glog.V(2) {
 		glog.Infoln("resetDelayedEvents", ownerId)
@@ -224,14 +798,167 @@ func (self *MySQLStore) resetDelayedEvents(ownerId string) error {
 	return nil
 }
 
-func (self *MySQLStore) getEvents(seq int32, ownerId string) (err error, events []*Event) {
+// Cancelled returns the ids out of evIds whose events have been cancelled, BulkChunkSize events per statement
+func (self *MySQLStore) Cancelled(evIds []string) ([]string, error) {
+	cancelled := make([]string, 0)
+	for len(evIds) > 0 {
+		n := len(evIds)
+		if n > self.cfg.BulkChunkSize {
+			n = self.cfg.BulkChunkSize
+		}
+		query := fmt.Sprintf(SQL_TMPL_SELECT_STATUS, self.cfg.TableName, placeholders(n))
+		rows, err := self.db.Query(query, append([]interface{}{EventStatus_CANCEL}, stringArgs(evIds[:n])...)...)
+		if err != nil {
+			glog.Errorln("Cancelled:", err)
+			self.nbError.Next()
+			return cancelled, err
+		}
+		for rows.Next() {
+			var evId string
+			if err := rows.Scan(&evId); err != nil {
+				rows.Close()
+				return cancelled, err
+			}
+			cancelled = append(cancelled, evId)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return cancelled, err
+		}
+		evIds = evIds[n:]
+	}
+	return cancelled, nil
+}
+
+// RenewOwnership extends owner_lock_time of events still owned by owner, BulkChunkSize events per statement
+func (self *MySQLStore) RenewOwnership(owner string, evIds []string) (int, error) {
+	if glog.V(2) {
+		glog.Infoln("RenewOwnership", owner, len(evIds))
+	}
+	self.nbRenew.Next()
+
+	total, err := self.updateOwnedIds(SQL_TMPL_RENEW_OWNERSHIP, owner, evIds)
+	if err != nil {
+		glog.Errorln("RenewOwnership:", err, owner)
+	}
+	return total, err
+}
+
+// ReleaseOwnership resets events still owned by owner to be claimed again by any consumer,
+// BulkChunkSize events per statement
+func (self *MySQLStore) ReleaseOwnership(owner string, evIds []string) (int, error) {
+	glog.Infoln("ReleaseOwnership", owner, len(evIds))
+	self.nbRelease.Next()
+
+	total, err := self.updateOwnedIds(SQL_TMPL_RELEASE_OWNERSHIP, owner, evIds)
+	if err != nil {
+		glog.Errorln("ReleaseOwnership:", err, owner)
+	}
+	return total, err
+}
+
+// updateOwnedIds runs tmpl on chunks of evIds owned by owner, returns the number of updated events
+func (self *MySQLStore) updateOwnedIds(tmpl string, owner string, evIds []string) (int, error) {
+	total := 0
+	for len(evIds) > 0 {
+		n := len(evIds)
+		if n > self.cfg.BulkChunkSize {
+			n = self.cfg.BulkChunkSize
+		}
+		query := fmt.Sprintf(tmpl, placeholders(n))
+		res, err := self.db.Exec(query, append([]interface{}{owner}, stringArgs(evIds[:n])...)...)
+		if err != nil {
+			self.nbError.Next()
+			return total, err
+		}
+		rowsAffected, _ := res.RowsAffected()
+		total += int(rowsAffected)
+		evIds = evIds[n:]
+	}
+	return total, nil
+}
+
+// heartbeat keeps ownerId among live consumers and returns them all, ordered by name.
+// Consumers missing 3 heartbeats are removed
+func (self *MySQLStore) heartbeat(ownerId string) ([]string, error) {
+	if glog.V(2) {
+		glog.Infoln("heartbeat", ownerId)
+	}
+	if _, err := self.db.Exec(SQL_HEARTBEAT_CONSUMER, ownerId); err != nil {
+		glog.Errorln("Heartbeat:", err, ownerId)
+		self.nbError.Next()
+		return nil, err
+	}
+	if res, err := self.db.Exec(SQL_DELETE_DEAD_CONSUMERS); err != nil {
+		glog.Errorln("Delete dead consumers:", err, ownerId)
+		self.nbError.Next()
+		return nil, err
+	} else if rowsAffected, _ := res.RowsAffected(); rowsAffected > 0 {
+		glog.Warningln("Delete dead consumers:", rowsAffected, ownerId)
+	}
+
+	rows, err := self.db.Query(SQL_SELECT_CONSUMERS)
+	if err != nil {
+		glog.Errorln("Select consumers:", err, ownerId)
+		self.nbError.Next()
+		return nil, err
+	}
+	defer rows.Close()
+
+	var consumers []string
+	for rows.Next() {
+		var consumer string
+		if err := rows.Scan(&consumer); err != nil {
+			return nil, err
+		}
+		consumers = append(consumers, consumer)
+	}
+	return consumers, rows.Err()
+}
+
+// leave removes ownerId from live consumers, its partitions are taken over on the next heartbeat of the others
+func (self *MySQLStore) leave(ownerId string) error {
+	glog.Infoln("leave", ownerId)
+	if _, err := self.db.Exec(SQL_DELETE_CONSUMER, ownerId); err != nil {
+		glog.Errorln("Leave:", err, ownerId)
+		self.nbError.Next()
+		return err
+	}
+	return nil
+}
+
+// nextTriggerTime returns the earliest trigger time of unclaimed events, zero if there is none,
+// only events of partitions are looked for if not nil
+func (self *MySQLStore) nextTriggerTime(partitions []int) (time.Time, error) {
+	query, args := SQL_NEXT_TRIGGER_TIME, []interface{}{}
+	if partitions != nil {
+		query = fmt.Sprintf(SQL_TMPL_NEXT_PARTITION_TRIGGER_TIME, placeholders(len(partitions)))
+		args = intArgs(partitions)
+	}
+	var next mysql.NullTime
+	if err := self.db.QueryRow(query, args...).Scan(&next); err != nil {
+		glog.Errorln("nextTriggerTime:", err)
+		self.nbError.Next()
+		return time.Time{}, err
+	}
+	return next.Time, nil
+}
+
+// getEvents claims limit events at most for ownerId, only events of partitions if not nil
+func (self *MySQLStore) getEvents(seq int32, ownerId string, limit int, partitions []int) (err error, events []*Event) {
 	__begin := time.Now()
 	err = nil
 	events = nil
 	// declare ownership
-	upperTimeStamp := time.Now().Add(self.timeWindow).Unix()
-	upperId := strconv.FormatInt(upperTimeStamp, 10)
-	_, err = self.db.Exec(SQL_DECLARE_OWNERSHIP, ownerId, seq, upperId)
+	upperTime := time.Now().Add(self.timeWindow)
+	upperId := strconv.FormatInt(upperTime.Unix(), 10)
+	if partitions == nil {
+		_, err = self.db.Exec(SQL_DECLARE_OWNERSHIP, ownerId, seq, upperId, upperTime, limit)
+	} else {
+		query := fmt.Sprintf(SQL_TMPL_DECLARE_PARTITION_OWNERSHIP, placeholders(len(partitions)))
+		args := append([]interface{}{ownerId, seq, upperId, upperTime}, intArgs(partitions)...)
+		_, err = self.db.Exec(query, append(args, limit)...)
+	}
 	if err != nil {
 		glog.Errorln("Declare ownership:", err, ownerId)
 		self.nbError.Next()
@@ -245,36 +972,13 @@ func (self *MySQLStore) getEvents(seq int32, ownerId string) (err error, events
 	}
 	defer rows.Close()
 
-	var strData string
-	for rows.Next() {
-		ev := &Event{}
-		errScan := rows.Scan(
-			&ev.Id,
-			&ev.TriggerType,
-			&ev.TriggerTime,
-			&ev.Attempts,
-			&strData,
-			&ev.Status,
-		)
-		if errScan != nil {
-			err = errScan
-			return
-		}
-
-		var data interface{}
-		decoder := json.NewDecoder(strings.NewReader(strData))
-		decoder.UseNumber()
-		decoder.Decode(&data)
-
-		ev.Data = data
-		events = append(events, ev)
-	}
-
-	errRows := rows.Err()
-	if errRows != nil {
-		err = errRows
+	if events, err = scanEvents(rows); err != nil {
 		return
 	}
+	for _, ev := range events {
+		ev.Owner = ownerId
+		ev.OwnerSeq = seq
+	}
 
 	du := time.Since(__begin)
 	if len(events) > 0 {
@@ -283,6 +987,138 @@ func (self *MySQLStore) getEvents(seq int32, ownerId string) (err error, events
 	return
 }
 
+type followUps struct {
+	OnSuccess *FollowUp
+	OnFailure *FollowUp
+}
+
+func encodeFollowUps(ev *Event) interface{} {
+	if ev.OnSuccess == nil && ev.OnFailure == nil {
+		return nil
+	}
+	jsonBytes, _ := Encoder.Marshal(&followUps{ev.OnSuccess, ev.OnFailure})
+	return strings.TrimSpace(string(jsonBytes))
+}
+
+func decodeFollowUps(ev *Event, strFollowUps string) {
+	if strFollowUps == "" {
+		return
+	}
+	var f followUps
+	decoder := json.NewDecoder(strings.NewReader(strFollowUps))
+	decoder.UseNumber()
+	if err := decoder.Decode(&f); err != nil {
+		glog.Errorln("Decode follow-ups:", err, ev.Id)
+		return
+	}
+	ev.OnSuccess = f.OnSuccess
+	ev.OnFailure = f.OnFailure
+}
+
+// "?, ?, ..." for IN clauses
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func stringArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
+
+func intArgs(values []int) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
+
+// zero time is stored as NULL
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// scans rows selected with SQL_EVENT_COLUMNS
+func scanEvents(rows *sql.Rows) ([]*Event, error) {
+	var events []*Event
+	for rows.Next() {
+		ev, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// scans the current row selected with SQL_EVENT_COLUMNS followed by columns scanned into extra
+func scanEvent(rows *sql.Rows, extra ...interface{}) (*Event, error) {
+	var (
+		strData        string
+		intervalMSec   int64
+		deadline       mysql.NullTime
+		endTime        mysql.NullTime
+		occurrenceTime mysql.NullTime
+		followUps      sql.NullString
+		firstAttempt   mysql.NullTime
+	)
+	ev := &Event{}
+	dest := []interface{}{
+		&ev.Id,
+		&ev.TriggerType,
+		&ev.TriggerTime,
+		&ev.Attempts,
+		&strData,
+		&ev.Status,
+		&ev.GroupKey,
+		&ev.ParentId,
+		&deadline,
+		&ev.CronSpec,
+		&intervalMSec,
+		&ev.MaxOccurrences,
+		&endTime,
+		&ev.Occurrence,
+		&occurrenceTime,
+		&followUps,
+		&firstAttempt,
+		&ev.BackoffPolicy,
+	}
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	ev.Interval = time.Duration(intervalMSec) * time.Millisecond
+	ev.Deadline = deadline.Time
+	ev.EndTime = endTime.Time
+	ev.FirstAttempt = firstAttempt.Time
+	ev.OccurrenceTime = occurrenceTime.Time
+	decodeFollowUps(ev, followUps.String)
+	ev.Data = decodeData(strData)
+	return ev, nil
+}
+
+func encodeData(data interface{}) string {
+	jsonBytes, _ := Encoder.Marshal(data)
+	return strings.TrimSpace(string(jsonBytes))
+}
+
+func decodeData(strData string) interface{} {
+	var data interface{}
+	decoder := json.NewDecoder(strings.NewReader(strData))
+	decoder.UseNumber()
+	decoder.Decode(&data)
+	return data
+}
+
 func (self *MySQLStore) GetStat(reset bool) map[string]interface{} {
 	stat := map[string]interface{}{
 		"nbError":    self.nbError.Get(),
@@ -290,7 +1126,17 @@ func (self *MySQLStore) GetStat(reset bool) map[string]interface{} {
 		"nbCancel":   self.nbCancel.Get(),
 		"nbComplete": self.nbComplete.Get(),
 		"nbRetry":    self.nbRetry.Get(),
+		"nbNext":     self.nbNext.Get(),
 		"nbReset":    self.nbReset.Get(),
+
+		"nbDeadLetter": self.nbDeadLetter.Get(),
+		"nbRedrive":    self.nbRedrive.Get(),
+		"nbPending":    self.nbPending.Get(),
+		"nbAck":        self.nbAck.Get(),
+		"nbRenew":      self.nbRenew.Get(),
+		"nbRelease":    self.nbRelease.Get(),
+
+		"nbLostOwnership": self.nbLostOwnership.Get(),
 	}
 	if reset {
 		self.nbError.Reset()
@@ -298,7 +1144,15 @@ func (self *MySQLStore) GetStat(reset bool) map[string]interface{} {
 		self.nbCancel.Reset()
 		self.nbComplete.Reset()
 		self.nbRetry.Reset()
+		self.nbNext.Reset()
 		self.nbReset.Reset()
+		self.nbDeadLetter.Reset()
+		self.nbRedrive.Reset()
+		self.nbPending.Reset()
+		self.nbAck.Reset()
+		self.nbRenew.Reset()
+		self.nbRelease.Reset()
+		self.nbLostOwnership.Reset()
 	}
 
 	return stat
@@ -317,7 +1171,7 @@ func TestOnly_ResetDb(cfg *MySQLConfig) {
 }
 
 func TestOnly_SelectEvents(cfg *MySQLConfig) []*Event {
-	SELECT_SQL := fmt.Sprintf(`SELECT id, trigger_type, trigger_time, retry_attempts, status, owner
+	SELECT_SQL := fmt.Sprintf(`SELECT id, trigger_type, trigger_time, retry_attempts, status, group_key, owner
  FROM %s`, cfg.TableName)
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true",
 		cfg.User, cfg.Pass, cfg.Host, cfg.Port, cfg.DbName)
@@ -340,6 +1194,7 @@ func TestOnly_SelectEvents(cfg *MySQLConfig) []*Event {
 			&ev.TriggerTime,
 			&ev.Attempts,
 			&ev.Status,
+			&ev.GroupKey,
 			&ev.Owner,
 		); err != nil {
 			glog.Errorln("TestSelectEvents err:", err)
@@ -1,6 +1,7 @@
 package futurama
 
 import (
+	"database/sql"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -108,6 +109,7 @@ func TestStore_UpdateForRetry(t *testing.T) {
 	ev := NewEvent(Test_TriggerType_Default, triggerTime, nil)
 	ev.Attempts = 10
 	ev.Id = evId
+	ev.OccurrenceTime = triggerTime.Add(-time.Minute)
 	if err := store.UpdateForRetry(ev, nil); err != nil {
 		assert.Fail(err.Error())
 		return
@@ -123,4 +125,248 @@ func TestStore_UpdateForRetry(t *testing.T) {
 	assert.Equal(gotEv.Attempts, ev.Attempts)
 	assert.Equal(int(gotEv.Status), EventStatus_DEFAULT)
 	assert.Equal(gotEv.Owner, "")
+
+	gotEv, err := store.Get(evId)
+	assert.NoError(err)
+	assert.WithinDuration(ev.OccurrenceTime, gotEv.OccurrenceTime, 50*time.Millisecond)
+}
+
+func TestStore_LostOwnership(t *testing.T) {
+	TestStore_Save(t)
+
+	assert := assert.New(t)
+	cfg := DefaultConfig()
+	store := NewMySQLStore(cfg)
+	store.Open()
+	defer store.Close()
+
+	// taken by a slow consumer, then by another one after its lock expired
+	err, events := store.getEvents(1, "slow", cfg.ConsumerSelectLimit, nil)
+	assert.NoError(err)
+	assert.Len(events, 1)
+	stale := events[0]
+	assert.Equal("slow", stale.Owner)
+	assert.EqualValues(1, stale.OwnerSeq)
+	sql := fmt.Sprintf(`UPDATE %s SET owner='', owner_lock_time=NULL`, cfg.TableName)
+	store.db.Exec(sql)
+	err, events = store.getEvents(2, cfg.ConsumerName, cfg.ConsumerSelectLimit, nil)
+	assert.NoError(err)
+	assert.Len(events, 1)
+
+	// writes of the slow consumer leave the event to its new owner
+	stale.TriggerTime = time.Now().Add(time.Hour)
+	stale.Attempts++
+	assert.Equal(ErrLostOwnership, store.UpdateForRetry(stale, nil))
+	assert.Equal(ErrLostOwnership, store.UpdateForNext(stale))
+	assert.Equal(ErrLostOwnership, store.UpdateOwnedStatus(stale, EventStatus_OK))
+	followUp := NewEvent(Test_TriggerType_Default, time.Now(), nil)
+	assert.Equal(ErrLostOwnership, store.UpdateStatusAndSave(stale, EventStatus_OK, []*Event{followUp}))
+	assert.Empty(followUp.Id)
+	assert.Equal(ErrLostOwnership, store.DeadLetter(stale, nil, nil))
+
+	evList := TestOnly_SelectEvents(&cfg.MySQLConfig)
+	assert.Len(evList, 1)
+	assert.Equal(cfg.ConsumerName, evList[0].Owner)
+	assert.Equal(0, evList[0].Attempts)
+
+	// the new owner completes it
+	assert.NoError(store.UpdateOwnedStatus(events[0], EventStatus_OK))
+	assert.Len(TestOnly_SelectEvents(&cfg.MySQLConfig), 0)
+
+	stat := store.GetStat(false)
+	assert.EqualValues(5, stat["nbLostOwnership"])
+	assert.EqualValues(0, stat["nbError"])
+}
+
+func TestStore_Migrate(t *testing.T) {
+	cfg := DefaultConfig()
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+
+	// tables of the first version
+	db, _ := sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:%d)/",
+		cfg.User, cfg.Pass, cfg.Host, cfg.Port))
+	db.Exec(fmt.Sprintf(SQL_TMPL_CREATE_DATABASE, cfg.DbName))
+	db.Exec(fmt.Sprintf(`CREATE TABLE %s.%s (
+ id VARCHAR(128) NOT NULL,
+ trigger_type VARCHAR(64),
+ trigger_time DATETIME(6) NOT NULL,
+ retry_attempts INT DEFAULT 0,
+ data TEXT,
+ status INT,
+ owner VARCHAR(64) NOT NULL DEFAULT '',
+ owner_lock_time DATETIME(6) DEFAULT NULL,
+ owner_seq BIGINT NOT NULL DEFAULT 0,
+ time_created DATETIME(6),
+ PRIMARY KEY(id))`, cfg.DbName, cfg.TableName))
+	db.Exec(fmt.Sprintf(`INSERT INTO %s.%s (id, trigger_type, trigger_time, status) VALUES (?, ?, NOW(), 0)`,
+		cfg.DbName, cfg.TableName), "1500000000_id", Test_TriggerType_Default)
+	db.Close()
+
+	assert := assert.New(t)
+	store := NewMySQLStore(cfg)
+	assert.NoError(store.Open())
+	defer store.Close()
+
+	// existing events are partitioned
+	var partitionId uint32
+	assert.NoError(store.db.QueryRow(fmt.Sprintf(`SELECT partition_id FROM %s WHERE id=?`, cfg.TableName),
+		"1500000000_id").Scan(&partitionId))
+	assert.Equal(partitionKey("1500000000_id"), partitionId)
+
+	ev := NewEvent(Test_TriggerType_Default, time.Now(), nil)
+	ev.GroupKey = "player1"
+	assert.NotEmpty(store.Save(ev))
+	count, err := store.CountWhere(&EventFilter{GroupKey: "player1"})
+	assert.NoError(err)
+	assert.Equal(1, count)
+
+	// added in the meantime by another instance
+	_, err = store.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", cfg.TableName, SQL_EVENT_ADDED_COLUMNS[0]))
+	assert.True(isMySQLError(err, mysqlErrDupFieldName))
+	_, err = store.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD %s", cfg.TableName, SQL_EVENT_ADDED_INDEXES[0]))
+	assert.True(isMySQLError(err, mysqlErrDupKeyName))
+
+	// nothing to add once migrated
+	store.Close()
+	assert.NoError(store.Open())
+}
+
+func TestStore_SaveJob(t *testing.T) {
+	cfg := DefaultConfig()
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	store := NewMySQLStore(cfg)
+	store.Open()
+	defer store.Close()
+
+	assert := assert.New(t)
+	job := NewEvent(Test_TriggerType_Default, time.Now().Add(-time.Second), nil)
+	job.Id = JobId("job")
+	job.CronSpec = "* * * * * *"
+	assert.NoError(store.SaveJob(job))
+	err, events := store.getEvents(1, "dead", cfg.ConsumerSelectLimit, nil)
+	assert.NoError(err)
+	assert.Len(events, 1)
+
+	// cancelled, then registered again before its cancellation is completed
+	assert.NoError(store.Cancel(job.Id))
+	job.TriggerTime = time.Now().Add(time.Hour)
+	assert.NoError(store.SaveJob(job))
+	assert.NoError(store.UpdateStatus(job.Id, EventStatus_CANCEL))
+
+	evList := TestOnly_SelectEvents(&cfg.MySQLConfig)
+	assert.Len(evList, 1)
+	assert.Equal(EventStatus_DEFAULT, int(evList[0].Status))
+	assert.Equal("", evList[0].Owner)
+	assert.WithinDuration(job.TriggerTime, evList[0].TriggerTime, time.Second)
+
+	// kept unless the spec changes, then taken from its owner
+	sql := fmt.Sprintf(`UPDATE %s SET owner='dead'`, cfg.TableName)
+	store.db.Exec(sql)
+	rescheduled := job.TriggerTime
+	job.TriggerTime = time.Now().Add(2 * time.Hour)
+	assert.NoError(store.SaveJob(job))
+	evList = TestOnly_SelectEvents(&cfg.MySQLConfig)
+	assert.Equal("dead", evList[0].Owner)
+	assert.WithinDuration(rescheduled, evList[0].TriggerTime, time.Second)
+
+	job.CronSpec = "*/2 * * * * *"
+	assert.NoError(store.SaveJob(job))
+	evList = TestOnly_SelectEvents(&cfg.MySQLConfig)
+	assert.Equal("", evList[0].Owner)
+	assert.WithinDuration(job.TriggerTime, evList[0].TriggerTime, time.Second)
+}
+
+func TestStore_CancelWhere(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.BulkChunkSize = 2
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	store := NewMySQLStore(cfg)
+	store.Open()
+	defer store.Close()
+	assert := assert.New(t)
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		store.Save(NewEvent(Test_TriggerType_Default, now.Add(time.Duration(i)*time.Second), nil))
+	}
+	store.Save(NewEvent(Test_TriggerType_Retry, now, nil))
+
+	_, err := store.CancelWhere(&EventFilter{})
+	assert.Error(err)
+
+	n, err := store.CancelWhere(&EventFilter{
+		TriggerType: Test_TriggerType_Default,
+		From:        now.Add(time.Second),
+		To:          now.Add(5 * time.Second),
+	})
+	assert.NoError(err)
+	assert.Equal(4, n)
+
+	evList := TestOnly_SelectEvents(&cfg.MySQLConfig)
+	assert.Len(evList, 6)
+	nbCancelled := 0
+	for _, ev := range evList {
+		if ev.Status == EventStatus_CANCEL {
+			assert.Equal(ev.TriggerType, Test_TriggerType_Default)
+			nbCancelled++
+		}
+	}
+	assert.Equal(4, nbCancelled)
+
+	// already cancelled events are not counted again
+	n, err = store.CancelWhere(&EventFilter{TriggerType: Test_TriggerType_Default})
+	assert.NoError(err)
+	assert.Equal(1, n)
+}
+
+func TestStore_CancelCascade(t *testing.T) {
+	cfg := DefaultConfig()
+	TestOnly_ResetDb(&cfg.MySQLConfig)
+	store := NewMySQLStore(cfg)
+	store.Open()
+	defer store.Close()
+	assert := assert.New(t)
+
+	now := time.Now()
+	save := func(parentId string) string {
+		ev := NewEvent(Test_TriggerType_Default, now, nil)
+		ev.ParentId = parentId
+		return store.Save(ev)
+	}
+	rootId := save("")
+	childId1 := save(rootId)
+	childId2 := save(rootId)
+	grandChildId := save(childId1)
+	otherId := save("")
+
+	ev, err := store.Get(childId1)
+	assert.NoError(err)
+	assert.Equal(rootId, ev.ParentId)
+	assert.Equal([]string{grandChildId}, ev.Children)
+
+	ev, err = store.Get(rootId)
+	assert.NoError(err)
+	assert.Len(ev.Children, 2)
+	assert.Contains(ev.Children, childId1)
+	assert.Contains(ev.Children, childId2)
+
+	ev, err = store.Get("unknown_id")
+	assert.NoError(err)
+	assert.Nil(ev)
+
+	// descendants are cancelled even if the root has been completed
+	store.UpdateStatus(rootId, EventStatus_OK)
+	cancelled, err := store.CancelCascade(rootId)
+	assert.NoError(err)
+	assert.Len(cancelled, 3)
+	assert.NotContains(cancelled, rootId)
+	assert.NotContains(cancelled, otherId)
+
+	for _, ev := range TestOnly_SelectEvents(&cfg.MySQLConfig) {
+		if ev.Id == otherId {
+			assert.Equal(int(ev.Status), EventStatus_DEFAULT)
+		} else {
+			assert.Equal(int(ev.Status), EventStatus_CANCEL)
+		}
+	}
 }
@@ -0,0 +1,251 @@
+package futurama
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	wheelBits   = 6
+	wheelSize   = 1 << wheelBits
+	wheelMask   = wheelSize - 1
+	wheelLevels = 6
+)
+
+// timingWheel fires events on ticks of a hierarchical wheel: level 0 has a slot per tick, each slot of level n
+// spans all the slots of level n-1 and is cascaded down when its time comes. Scheduling and removing are O(1),
+// except when full: evicting the latest event scans scheduled events
+type timingWheel struct {
+	mutex   sync.Mutex
+	tick    time.Duration
+	origin  time.Time
+	maxSize int
+	fire    func(ev *Event)
+
+	// last processed tick since origin
+	current int64
+	slots   [wheelLevels][wheelSize]*list.List
+	// scheduled events, fired ones are kept until removed
+	entries map[string]*wheelEntry
+
+	quit chan bool
+}
+
+type wheelEntry struct {
+	ev *Event
+	// tick to fire at
+	expiry int64
+	slot   *list.List
+	elem   *list.Element
+}
+
+func newTimingWheel(maxSize int, tick time.Duration, fire func(ev *Event)) *timingWheel {
+	if tick <= 0 {
+		tick = 10 * time.Millisecond
+	}
+	wheel := &timingWheel{
+		tick:    tick,
+		origin:  time.Now(),
+		maxSize: maxSize,
+		fire:    fire,
+		entries: make(map[string]*wheelEntry),
+	}
+	for level := range wheel.slots {
+		for slot := range wheel.slots[level] {
+			wheel.slots[level][slot] = list.New()
+		}
+	}
+	return wheel
+}
+
+func (self *timingWheel) schedule(ev *Event, at time.Time) *Event {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if _, ok := self.entries[ev.Id]; ok {
+		return ev
+	}
+	var evicted *Event
+	if len(self.entries) >= self.maxSize {
+		latest := self.latest()
+		if latest == nil || !ev.TriggerTime.Before(latest.ev.TriggerTime) {
+			return ev
+		}
+		self.unlink(latest)
+		delete(self.entries, latest.ev.Id)
+		evicted = latest.ev
+	}
+
+	entry := &wheelEntry{ev: ev, expiry: self.ticks(at)}
+	self.entries[ev.Id] = entry
+	self.place(entry, self.current+1)
+	return evicted
+}
+
+func (self *timingWheel) get(evId string) *Event {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if entry, ok := self.entries[evId]; ok {
+		return entry.ev
+	}
+	return nil
+}
+
+func (self *timingWheel) remove(evId string) *Event {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	entry, ok := self.entries[evId]
+	if !ok {
+		return nil
+	}
+	self.unlink(entry)
+	delete(self.entries, evId)
+	return entry.ev
+}
+
+func (self *timingWheel) events() []*Event {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	events := make([]*Event, 0, len(self.entries))
+	for _, entry := range self.entries {
+		events = append(events, entry.ev)
+	}
+	return events
+}
+
+func (self *timingWheel) len() int {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return len(self.entries)
+}
+
+func (self *timingWheel) capacity() int {
+	return self.maxSize
+}
+
+// start runs the ticker, events due while stopped are fired on the first tick
+func (self *timingWheel) start() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.quit != nil {
+		return
+	}
+	quit := make(chan bool)
+	self.quit = quit
+	go func() {
+		ticker := time.NewTicker(self.tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case now := <-ticker.C:
+				self.advance(now)
+			}
+		}
+	}()
+}
+
+func (self *timingWheel) stop() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.quit != nil {
+		close(self.quit)
+		self.quit = nil
+	}
+}
+
+// advance processes ticks up to now, catching up with ticks missed by a late ticker
+func (self *timingWheel) advance(now time.Time) {
+	var due []*Event
+	self.mutex.Lock()
+	for target := int64(now.Sub(self.origin) / self.tick); self.current < target; {
+		self.current++
+		due = self.process(self.current, due)
+	}
+	self.mutex.Unlock()
+
+	for _, ev := range due {
+		go self.fire(ev)
+	}
+}
+
+// process cascades slots of upper levels starting at tick, then appends events of the level 0 slot to due
+func (self *timingWheel) process(tick int64, due []*Event) []*Event {
+	for level := wheelLevels - 1; level > 0; level-- {
+		if tick&(1<<(wheelBits*uint(level))-1) != 0 {
+			continue
+		}
+		slot := self.slots[level][(tick>>(wheelBits*uint(level)))&wheelMask]
+		for elem := slot.Front(); elem != nil; {
+			next := elem.Next()
+			entry := elem.Value.(*wheelEntry)
+			slot.Remove(elem)
+			entry.slot, entry.elem = nil, nil
+			self.place(entry, tick)
+			elem = next
+		}
+	}
+
+	slot := self.slots[0][tick&wheelMask]
+	for elem := slot.Front(); elem != nil; {
+		next := elem.Next()
+		entry := elem.Value.(*wheelEntry)
+		slot.Remove(elem)
+		entry.slot, entry.elem = nil, nil
+		due = append(due, entry.ev)
+		elem = next
+	}
+	return due
+}
+
+// place links entry to the slot of the lowest level spanning its expiry from the first unprocessed tick,
+// entries later than the span of the wheel are cascaded until they fit
+func (self *timingWheel) place(entry *wheelEntry, from int64) {
+	expiry := entry.expiry
+	if expiry < from {
+		expiry = from
+	}
+	delta := expiry - from
+	level := 0
+	for level < wheelLevels-1 && delta >= 1<<(wheelBits*uint(level+1)) {
+		level++
+	}
+	if maxDelta := int64(1)<<(wheelBits*wheelLevels) - 1; delta > maxDelta {
+		expiry = from + maxDelta
+	}
+	slot := self.slots[level][(expiry>>(wheelBits*uint(level)))&wheelMask]
+	entry.slot = slot
+	entry.elem = slot.PushBack(entry)
+}
+
+func (self *timingWheel) unlink(entry *wheelEntry) {
+	if entry.elem != nil {
+		entry.slot.Remove(entry.elem)
+		entry.slot, entry.elem = nil, nil
+	}
+}
+
+// latest returns the scheduled entry with the latest trigger time
+func (self *timingWheel) latest() *wheelEntry {
+	var latest *wheelEntry
+	for _, entry := range self.entries {
+		if latest == nil || entry.ev.TriggerTime.After(latest.ev.TriggerTime) {
+			latest = entry
+		}
+	}
+	return latest
+}
+
+// ticks returns the tick of t since origin, rounded up so that events are not fired early
+func (self *timingWheel) ticks(t time.Time) int64 {
+	du := t.Sub(self.origin)
+	if du <= 0 {
+		return 0
+	}
+	return int64((du + self.tick - 1) / self.tick)
+}
@@ -0,0 +1,170 @@
+package futurama
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// workerPool runs due events within the global concurrency limit and the ones of their trigger types,
+// events over the limits wait in trigger time order
+type workerPool struct {
+	mutex          sync.Mutex
+	maxConcurrency int
+	typeLimit      func(triggerType string) int
+	run            func(ev *Event)
+
+	running       int
+	runningByType map[string]int
+	// waiting events by trigger type
+	waiting   map[string]*PQ
+	nbWaiting int
+
+	// of queued events started since the last reset
+	nbQueued  Seq32
+	nbWaited  int
+	waitTotal time.Duration
+	waitMax   time.Duration
+}
+
+type waitingEvent struct {
+	ev     *Event
+	queued time.Time
+}
+
+func (self *waitingEvent) GetKey() string {
+	return self.ev.Id
+}
+
+// maxConcurrency and typeLimit(triggerType) are not limited if 0
+func newWorkerPool(maxConcurrency int, typeLimit func(triggerType string) int, run func(ev *Event)) *workerPool {
+	return &workerPool{
+		maxConcurrency: maxConcurrency,
+		typeLimit:      typeLimit,
+		run:            run,
+		runningByType:  make(map[string]int),
+		waiting:        make(map[string]*PQ),
+	}
+}
+
+// submit runs ev in the calling goroutine (along with following waiting events), or queues it
+func (self *workerPool) submit(ev *Event) {
+	self.mutex.Lock()
+	if !self.acquire(ev.TriggerType) {
+		pq, ok := self.waiting[ev.TriggerType]
+		if !ok {
+			pq = NewPQ(false, math.MaxInt32)
+			self.waiting[ev.TriggerType] = pq
+		}
+		if index, _ := pq.Push(&waitingEvent{ev, time.Now()}, ev.TriggerTime.UnixNano()); index >= 0 {
+			self.nbWaiting++
+			self.nbQueued.Next()
+		}
+		self.mutex.Unlock()
+		return
+	}
+	self.mutex.Unlock()
+
+	for ev != nil {
+		self.run(ev)
+
+		self.mutex.Lock()
+		self.release(ev.TriggerType)
+		ev = self.next()
+		self.mutex.Unlock()
+	}
+}
+
+func (self *workerPool) acquire(triggerType string) bool {
+	if self.maxConcurrency > 0 && self.running >= self.maxConcurrency {
+		return false
+	}
+	if limit := self.typeLimit(triggerType); limit > 0 && self.runningByType[triggerType] >= limit {
+		return false
+	}
+	self.running++
+	self.runningByType[triggerType]++
+	return true
+}
+
+func (self *workerPool) release(triggerType string) {
+	self.running--
+	if self.runningByType[triggerType]--; self.runningByType[triggerType] <= 0 {
+		delete(self.runningByType, triggerType)
+	}
+}
+
+// next acquires the earliest waiting event among trigger types under their limits
+func (self *workerPool) next() *Event {
+	if self.nbWaiting == 0 {
+		return nil
+	}
+	var nextType string
+	var nextItem *waitingEvent
+	for triggerType, pq := range self.waiting {
+		item := pq.Top().(*waitingEvent)
+		if nextItem != nil && !item.ev.TriggerTime.Before(nextItem.ev.TriggerTime) {
+			continue
+		}
+		if limit := self.typeLimit(triggerType); limit > 0 && self.runningByType[triggerType] >= limit {
+			continue
+		}
+		nextType, nextItem = triggerType, item
+	}
+	if nextItem == nil || !self.acquire(nextType) {
+		return nil
+	}
+
+	pq := self.waiting[nextType]
+	pq.Pop()
+	if pq.Len() == 0 {
+		delete(self.waiting, nextType)
+	}
+	self.nbWaiting--
+
+	wait := time.Since(nextItem.queued)
+	self.nbWaited++
+	self.waitTotal += wait
+	if wait > self.waitMax {
+		self.waitMax = wait
+	}
+	return nextItem.ev
+}
+
+// nbRunning returns the number of events being run
+func (self *workerPool) nbRunning() int {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.running
+}
+
+// clear drops waiting events, running ones are kept running
+func (self *workerPool) clear() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.waiting = make(map[string]*PQ)
+	self.nbWaiting = 0
+}
+
+// getStat adds stats of the pool to stat of the scheduler
+func (self *workerPool) getStat(stat map[string]interface{}, reset bool) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	var waitAvg time.Duration
+	if self.nbWaited > 0 {
+		waitAvg = self.waitTotal / time.Duration(self.nbWaited)
+	}
+	stat["nbRunning"] = self.running
+	stat["nbWaiting"] = self.nbWaiting
+	stat["nbQueued"] = self.nbQueued.Get()
+	stat["waitAvgMSec"] = int64(waitAvg / time.Millisecond)
+	stat["waitMaxMSec"] = int64(self.waitMax / time.Millisecond)
+
+	if reset {
+		self.nbQueued.Reset()
+		self.nbWaited = 0
+		self.waitTotal = 0
+		self.waitMax = 0
+	}
+}
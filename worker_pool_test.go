@@ -0,0 +1,102 @@
+package futurama
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool_Limits(t *testing.T) {
+	assert := assert.New(t)
+
+	var mutex sync.Mutex
+	var started []string
+	release := make(map[string]chan bool)
+	for _, id := range []string{"a1", "a2", "a3", "b1", "b2"} {
+		release[id] = make(chan bool)
+	}
+	pool := newWorkerPool(2, func(triggerType string) int {
+		if triggerType == "a" {
+			return 1
+		}
+		return 0
+	}, func(ev *Event) {
+		mutex.Lock()
+		started = append(started, ev.Id)
+		mutex.Unlock()
+		<-release[ev.Id]
+	})
+	getStarted := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string{}, started...)
+	}
+
+	now := time.Now()
+	submit := func(id string, triggerType string, triggerTime time.Time) {
+		ev := NewEvent(triggerType, triggerTime, nil)
+		ev.Id = id
+		go pool.submit(ev)
+		time.Sleep(10 * time.Millisecond)
+	}
+	submit("a1", "a", now)
+	submit("a2", "a", now.Add(2*time.Second))
+	submit("b1", "b", now.Add(time.Second))
+	submit("b2", "b", now.Add(3*time.Second))
+	submit("a3", "a", now.Add(-time.Second))
+
+	// a2 and a3 wait for a1 as "a" is limited to 1
+	assert.Equal([]string{"a1", "b1"}, getStarted())
+	stat := make(map[string]interface{})
+	pool.getStat(stat, false)
+	assert.Equal(2, stat["nbRunning"])
+	assert.Equal(3, stat["nbWaiting"])
+	assert.EqualValues(3, stat["nbQueued"])
+
+	// a3 is the earliest, but "a" is still running
+	release["b1"] <- true
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal([]string{"a1", "b1", "b2"}, getStarted())
+	release["a1"] <- true
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal([]string{"a1", "b1", "b2", "a3"}, getStarted())
+	release["b2"] <- true
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal([]string{"a1", "b1", "b2", "a3"}, getStarted())
+	release["a3"] <- true
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal([]string{"a1", "b1", "b2", "a3", "a2"}, getStarted())
+	release["a2"] <- true
+
+	time.Sleep(10 * time.Millisecond)
+	pool.getStat(stat, true)
+	assert.Equal(0, stat["nbRunning"])
+	assert.Equal(0, stat["nbWaiting"])
+	assert.True(stat["waitMaxMSec"].(int64) >= 10)
+	pool.getStat(stat, false)
+	assert.EqualValues(0, stat["nbQueued"])
+	assert.EqualValues(0, stat["waitMaxMSec"])
+}
+
+func TestWorkerPool_Unlimited(t *testing.T) {
+	assert := assert.New(t)
+
+	var wg sync.WaitGroup
+	release := make(chan bool)
+	pool := newWorkerPool(0, func(string) int { return 0 }, func(ev *Event) {
+		wg.Done()
+		<-release
+	})
+	wg.Add(100)
+	for i := 0; i < 100; i++ {
+		go pool.submit(NewEvent("a", time.Now(), nil))
+	}
+	wg.Wait()
+
+	stat := make(map[string]interface{})
+	pool.getStat(stat, false)
+	assert.Equal(100, stat["nbRunning"])
+	assert.Equal(0, stat["nbWaiting"])
+	close(release)
+}